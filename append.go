@@ -0,0 +1,396 @@
+package railing
+
+import (
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MarshalAppend appends the query string of v to dst and returns the extended
+// buffer. The result is the same as Encode of the Values returned by Marshal,
+// but structs and maps are written straight to dst, without building Values.
+// If an error occurs, dst is returned unchanged.
+func MarshalAppend(dst []byte, v interface{}) ([]byte, error) {
+	return EncoderOptions{}.MarshalAppend(dst, v)
+}
+
+// MarshalAppend appends the query string of v encoded according to the options
+// to dst. See MarshalAppend function for details.
+func (o EncoderOptions) MarshalAppend(dst []byte,
+	v interface{}) ([]byte, error) {
+	a := newAppender(o, dst, nil)
+	if err := a.value(reflect.ValueOf(v)); err != nil {
+		return dst, err
+	}
+	return a.dst, nil
+}
+
+// flushSize is the size of the buffer of the appender which writes to
+// io.Writer. Once the buffer grows beyond it, the buffer is written.
+const flushSize = 4096
+
+// appender writes the pairs of a value straight to the query string. It
+// follows the encoder step by step: the fields of a struct are written in the
+// order the encoder sorts them, and a struct which cannot be written directly,
+// eg. because of embedded fields, is marshaled by the encoder and its pairs are
+// written instead.
+//
+// key is the escaped key of the current value. element is true inside the
+// element of a slice of structs, where the values of the keys which are not
+// arrays are joined by a comma. If w is not nil, dst is written to it every
+// time it grows beyond flushSize; err is the first error returned by w.
+type appender struct {
+	*encoder
+	dst     []byte
+	pairs   int
+	key     []byte
+	element bool
+	escape  func(dst []byte, s string) []byte
+	w       io.Writer
+	err     error
+}
+
+func newAppender(o EncoderOptions, dst []byte, w io.Writer) *appender {
+	e := o.encoder()
+	return &appender{
+		encoder: e,
+		dst:     dst,
+		escape:  appendEscaper(e.dialect),
+		w:       w,
+	}
+}
+
+// flush writes dst to w and empties it.
+func (a *appender) flush() {
+	if a.err == nil {
+		_, a.err = a.w.Write(a.dst)
+	}
+	a.dst = a.dst[:0]
+}
+
+func (a *appender) value(v reflect.Value) error {
+	v = a.indirect(v)
+	if !v.IsValid() {
+		return nil
+	}
+	if m := a.marshaler(v); m != nil {
+		values, err := m.MarshalQuery()
+		if err != nil {
+			return &MarshalerError{v.Type(), err}
+		}
+		a.values(OrderedValues{Values: values.Values})
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Map:
+		return a.maps(v)
+	case reflect.Struct:
+		return a.object(v)
+	default:
+		return &UnsupportedTypeError{v.Type()}
+	}
+}
+
+func (a *appender) object(v reflect.Value) error {
+	fields := cachedFields(v.Type())
+	if !fields.direct {
+		m, err := a.marshal(v)
+		if err != nil {
+			return err
+		}
+		a.values(m)
+		return nil
+	}
+	list := fields.sorted
+	if a.ordered {
+		list = fields.encode
+	}
+	for _, f := range list {
+		fv := v.Field(f.index)
+		if f.tag.omitEmpty && isEmptyValue(fv) {
+			continue
+		}
+		if err := a.field(f.tag, fv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// field writes the field the same way encoder's marshalField does it.
+func (a *appender) field(tag tag, v reflect.Value) error {
+	v = a.indirect(v)
+	n := len(a.key)
+	defer func() { a.key = a.key[:n] }()
+	if !v.IsValid() {
+		if a.bareNils {
+			a.subKey(tag.name)
+			a.dst = a.nilPair()
+		}
+		return nil
+	}
+	if m := a.marshaler(v); m != nil {
+		values, err := m.MarshalQuery()
+		if err != nil {
+			return &MarshalerError{v.Type(), err}
+		}
+		a.subKey(tag.name)
+		a.values(OrderedValues{Values: values.Values})
+		return nil
+	}
+	if tag.delim != "" && isObjectType(v.Type()) {
+		m := NewOrderedValues()
+		if err := a.joinObject(tag, &m, v); err != nil {
+			return err
+		}
+		a.values(m)
+		return nil
+	}
+	if tag.multiparam && v.Type() == timeType {
+		m := NewOrderedValues()
+		a.multiparam(tag, &m, v)
+		a.values(m)
+		return nil
+	}
+	switch kind := v.Kind(); {
+	case isTextType(v.Type()):
+		a.subKey(tag.name)
+		return a.scalar(tag, v)
+	case kind == reflect.Slice || kind == reflect.Array:
+		return a.slices(tag, v)
+	case kind == reflect.Struct:
+		a.subKey(tag.name)
+		return a.object(v)
+	case kind == reflect.Map:
+		a.subKey(tag.name)
+		return a.maps(v)
+	default:
+		a.subKey(tag.name)
+		return a.scalar(tag, v)
+	}
+}
+
+// maps writes the map the same way encoder's maps does it.
+func (a *appender) maps(v reflect.Value) error {
+	keys, err := mapKeys(v)
+	if err != nil {
+		return err
+	}
+	top := len(a.key) == 0 && !a.ordered
+	sort.Slice(keys, func(i, j int) bool {
+		ki, kj := keys[i].s, keys[j].s
+		if top && topKey(ki) != topKey(kj) {
+			return topKey(ki) < topKey(kj)
+		}
+		return ki < kj
+	})
+	n := len(a.key)
+	for _, key := range keys {
+		vv := a.indirect(v.MapIndex(key.v))
+		if !vv.IsValid() {
+			if a.bareNils {
+				a.subKey(key.s)
+				a.dst = a.nilPair()
+				a.key = a.key[:n]
+			}
+			continue
+		}
+		switch kind := vv.Kind(); {
+		case isTextType(vv.Type()):
+			a.subKey(key.s)
+			err = a.scalar(tag{}, vv)
+		case kind == reflect.Slice || kind == reflect.Array:
+			err = a.slices(tag{name: key.s}, vv)
+		case kind == reflect.Struct:
+			a.subKey(key.s)
+			err = a.value(vv)
+		case kind == reflect.Map:
+			a.subKey(key.s)
+			err = a.maps(vv)
+		default:
+			a.subKey(key.s)
+			err = a.scalar(tag{}, vv)
+		}
+		a.key = a.key[:n]
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// slices writes the slice the same way encoder's slices does it.
+func (a *appender) slices(tag tag, v reflect.Value) error {
+	n := len(a.key)
+	if v.Len() < 1 {
+		if a.isEmptyArray(v) && a.subKey(emptyArrayKey(tag)) {
+			a.dst = a.pairKey()
+			a.key = a.key[:n]
+		}
+		return nil
+	}
+	if isStructSlice(v.Type()) {
+		return a.structSlices(tag, v)
+	}
+	defer func() { a.key = a.key[:n] }()
+	// Inside the element of a slice of structs, the repeated keys of an exploded
+	// form slice are joined by a comma as well.
+	if tag.delim != "" || tag.inline && a.element {
+		m := NewOrderedValues()
+		if err := a.encoder.slices(tag, &m, v); err != nil {
+			return err
+		}
+		a.values(m)
+		return nil
+	}
+	a.subKey(tag.name)
+	k, j := len(a.key), 0
+	for i := 0; i < v.Len(); i++ {
+		vv := a.indirect(v.Index(i))
+		if !vv.IsValid() {
+			continue
+		}
+		if !tag.inline {
+			a.key = a.escape(a.key, "[")
+			a.key = a.escape(a.key, a.dialect.Index(j, false))
+			a.key = a.escape(a.key, "]")
+		}
+		if err := a.scalar(tag, vv); err != nil {
+			return err
+		}
+		a.key, j = a.key[:k], j+1
+	}
+	return nil
+}
+
+// structSlices writes every element of the slice as an object under its own
+// key - "name[]" or "name[i]" - the same way encoder's structSlices does it.
+func (a *appender) structSlices(tag tag, v reflect.Value) error {
+	n, element := len(a.key), a.element
+	defer func() { a.key, a.element = a.key[:n], element }()
+	a.subKey(tag.name)
+	k := len(a.key)
+	a.element = true
+	for i := 0; i < v.Len(); i++ {
+		index := a.dialect.Index(i, true)
+		if a.indexed {
+			index = strconv.Itoa(i)
+		}
+		a.key = a.escape(a.key[:k], "[")
+		a.key = a.escape(a.key, index)
+		a.key = a.escape(a.key, "]")
+		if err := a.value(v.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scalar writes the pair of the current key and the simple value. Numbers and
+// bools are written without escaping, as they contain only safe characters.
+// Values encoded as text and durations, eg. "1.5µs", are always escaped.
+func (a *appender) scalar(tag tag, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Float32, reflect.Float64, reflect.Bool:
+		if isTextType(v.Type()) || v.Type() == durationType {
+			break
+		}
+		dst, err := a.appendConv(a.pairKey(), v)
+		if err != nil {
+			return err
+		}
+		a.dst = dst
+		return nil
+	}
+	str, err := a.conv(v, tag)
+	if err != nil {
+		return err
+	}
+	a.dst = a.escape(a.pairKey(), str)
+	return nil
+}
+
+// values writes the pairs of the nested Values under the current key. Inside
+// the element of a slice of structs, the values of the keys which are not
+// arrays are joined by a comma and nil keys get empty values, the same way
+// encoder's addElement does it.
+func (a *appender) values(m OrderedValues) {
+	n := len(a.key)
+	var joined map[string]bool
+	for _, p := range m.Pairs() {
+		value := p.Value
+		if a.element && !strings.Contains(p.Key, "[]") {
+			if joined[p.Key] {
+				continue
+			}
+			if joined == nil {
+				joined = make(map[string]bool)
+			}
+			joined[p.Key] = true
+			value = strings.Join(m.Values[p.Key], ",")
+		}
+		switch {
+		case !a.subKey(p.Key):
+		case !a.element && value == "" && m.IsNil(p.Key):
+			a.dst = a.nilKey()
+		default:
+			a.dst = a.escape(a.pairKey(), value)
+		}
+		a.key = a.key[:n]
+	}
+}
+
+// subKey appends the key of a nested value to the current key, the same way
+// mergeByKey builds it, eg. "foo[bar][]" for "bar[]" under "foo". It returns
+// false if the key cannot be nested.
+func (a *appender) subKey(k string) bool {
+	if len(a.key) == 0 {
+		a.key = a.escape(a.key, k)
+		return true
+	}
+	if k == "" {
+		return true
+	}
+	top, rest, ok := splitTop(k)
+	if !ok {
+		return false
+	}
+	a.key = a.escape(a.key, "[")
+	a.key = a.escape(a.key, top)
+	a.key = a.escape(a.key, "]")
+	a.key = a.escape(a.key, rest)
+	return true
+}
+
+// pairKey appends the separator, the current key and '=' to dst and returns
+// it.
+func (a *appender) pairKey() []byte {
+	return append(a.nilKey(), '=')
+}
+
+// nilPair appends the pair of the current key holding a nil value to dst and
+// returns it. Inside the element of a slice of structs, the pair gets an empty
+// value, as encoder's addElement does not keep nil keys.
+func (a *appender) nilPair() []byte {
+	if a.element {
+		return a.pairKey()
+	}
+	return a.nilKey()
+}
+
+// nilKey appends the separator and the current key - the pair of a nil key -
+// to dst and returns it.
+func (a *appender) nilKey() []byte {
+	if a.w != nil && len(a.dst) >= flushSize {
+		a.flush()
+	}
+	if a.pairs > 0 {
+		a.dst = append(a.dst, '&')
+	}
+	a.pairs++
+	return append(a.dst, a.key...)
+}
@@ -0,0 +1,127 @@
+package railing
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// largeForm returns a struct type with the given number of string fields and
+// as many nested structs of the same size, together with Values filling every
+// field of it.
+func largeForm(size int) (reflect.Type, Values) {
+	fields := make([]reflect.StructField, size)
+	for i := range fields {
+		fields[i] = reflect.StructField{
+			Name: fmt.Sprintf("Field%d", i),
+			Type: reflect.TypeOf(""),
+			Tag:  reflect.StructTag(fmt.Sprintf(`railing:"field_%d"`, i)),
+		}
+	}
+	nested := reflect.StructOf(fields)
+	for i := 0; i < size; i++ {
+		fields = append(fields, reflect.StructField{
+			Name: fmt.Sprintf("Nested%d", i),
+			Type: nested,
+			Tag:  reflect.StructTag(fmt.Sprintf(`railing:"nested_%d"`, i)),
+		})
+	}
+	m := NewValues()
+	for i := 0; i < size; i++ {
+		m.Add(fmt.Sprintf("field_%d", i), "value")
+		for j := 0; j < size; j++ {
+			m.Add(fmt.Sprintf("nested_%d[field_%d]", i, j), "value")
+		}
+	}
+	return reflect.StructOf(fields), m
+}
+
+func BenchmarkUnmarshalLarge(b *testing.B) {
+	typ, m := largeForm(30)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := Unmarshal(m, reflect.New(typ).Interface()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUnmarshalOrderedLarge(b *testing.B) {
+	typ, m := largeForm(30)
+	o := NewOrderedValues(m.Pairs()...)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := UnmarshalOrdered(o, reflect.New(typ).Interface()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMarshalLarge(b *testing.B) {
+	typ, m := largeForm(30)
+	v := reflect.New(typ)
+	if err := Unmarshal(m, v.Interface()); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Marshal(v.Interface()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncodeLarge(b *testing.B) {
+	_, m := largeForm(30)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.Encode()
+	}
+}
+
+func BenchmarkMarshalAppend(b *testing.B) {
+	in := orders{Orders: []order{
+		{ID: 1, Address: address{"New York"}, Items: []int{1, 2}},
+		{ID: 2, Lines: []item{{SKU: "a"}, {SKU: "b", Tags: []string{"x"}}}},
+	}}
+	var dst []byte
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var err error
+		if dst, err = MarshalAppend(dst[:0], in); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMarshalEncode(b *testing.B) {
+	in := orders{Orders: []order{
+		{ID: 1, Address: address{"New York"}, Items: []int{1, 2}},
+		{ID: 2, Lines: []item{{SKU: "a"}, {SKU: "b", Tags: []string{"x"}}}},
+	}}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m, err := Marshal(in)
+		if err != nil {
+			b.Fatal(err)
+		}
+		m.Encode()
+	}
+}
+
+func BenchmarkAppendEncode(b *testing.B) {
+	_, m := largeForm(10)
+	o := NewOrderedValues(m.Pairs()...)
+	var dst []byte
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dst = o.AppendEncode(dst[:0])
+	}
+}
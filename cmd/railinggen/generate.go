@@ -0,0 +1,665 @@
+//go:build go1.18
+// +build go1.18
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// basics maps the simple types to their bit sizes. The size of int and uint is
+// 0, which strconv understands as the size of int.
+var basics = map[string]int{
+	"string":  0,
+	"bool":    0,
+	"int":     0,
+	"int8":    8,
+	"int16":   16,
+	"int32":   32,
+	"rune":    32,
+	"int64":   64,
+	"uint":    0,
+	"uint8":   8,
+	"byte":    8,
+	"uint16":  16,
+	"uint32":  32,
+	"uint64":  64,
+	"float32": 32,
+	"float64": 64,
+}
+
+// kind is the way a field is encoded.
+type kind int
+
+const (
+	scalar  kind = iota // a simple value, eg. "id=1"
+	slice               // a slice of simple values, eg. "ids[]=1&ids[]=2"
+	object              // a nested struct, eg. "address[city]=NY"
+	objects             // a slice of structs, eg. "lines[][sku]=a"
+)
+
+// field is a struct field which is encoded.
+//
+// name  - is the name of the Go field.
+//
+// key   - is the name from the railing tag or the name of the field.
+//
+// ptr   - is true if the field, or the element of the slice of structs, is a
+// pointer.
+//
+// typ   - is the type of the field or of the elements of the slice.
+//
+// basic - is the simple type underlying typ. It is empty for structs and "len"
+// for other types which are not simple.
+//
+// text  - is true if typ implements encoding.TextMarshaler and
+// encoding.TextUnmarshaler. Such values are simple values.
+type field struct {
+	name      string
+	key       string
+	omitEmpty bool
+	comma     bool
+	kind      kind
+	ptr       bool
+	typ       string
+	basic     string
+	text      bool
+}
+
+// generator writes the methods of the types of a package. The nested structs
+// which are among the generated types are encoded and decoded by calling their
+// generated methods, other ones by railing.Marshal and railing.Unmarshal.
+type generator struct {
+	pkg       string
+	specs     map[string]*ast.TypeSpec
+	methods   map[string]map[string]bool
+	generated map[string]bool
+	imports   map[string]bool
+	buf       bytes.Buffer
+}
+
+// generate returns the formatted source of the file with the methods of the
+// named types of the package in dir.
+func generate(dir string, types []string) ([]byte, error) {
+	g, err := parsePackage(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range types {
+		g.generated[name] = true
+	}
+	for _, name := range types {
+		fields, err := g.fields(name)
+		if err != nil {
+			return nil, err
+		}
+		g.marshal(name, fields)
+		g.unmarshal(name, fields)
+	}
+	var src bytes.Buffer
+	fmt.Fprintf(&src, "// Code generated by railinggen -type=%s; DO NOT EDIT.\n\n",
+		strings.Join(types, ","))
+	fmt.Fprintf(&src, "package %s\n\nimport (\n", g.pkg)
+	for _, imp := range []string{"fmt", "reflect", "strconv", "strings"} {
+		if g.imports[imp] {
+			fmt.Fprintf(&src, "\t%q\n", imp)
+		}
+	}
+	fmt.Fprintf(&src, "\n\t\"github.com/jszwec/railing\"\n)\n")
+	src.Write(g.buf.Bytes())
+	out, err := format.Source(src.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("invalid generated code: %v", err)
+	}
+	return out, nil
+}
+
+// parsePackage reads the type declarations of the package in dir. Test files
+// are skipped.
+func parsePackage(dir string) (*generator, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+	g := &generator{
+		specs:     make(map[string]*ast.TypeSpec),
+		methods:   make(map[string]map[string]bool),
+		generated: make(map[string]bool),
+		imports:   make(map[string]bool),
+	}
+	fset := token.NewFileSet()
+	for _, name := range files {
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, name, nil, parser.SkipObjectResolution)
+		if err != nil {
+			return nil, err
+		}
+		if g.pkg != "" && g.pkg != f.Name.Name {
+			return nil, fmt.Errorf("multiple packages in %s: %s and %s", dir,
+				g.pkg, f.Name.Name)
+		}
+		g.pkg = f.Name.Name
+		for _, decl := range f.Decls {
+			if fn, ok := decl.(*ast.FuncDecl); ok {
+				g.method(fn)
+				continue
+			}
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				spec := spec.(*ast.TypeSpec)
+				g.specs[spec.Name.Name] = spec
+			}
+		}
+	}
+	if g.pkg == "" {
+		return nil, fmt.Errorf("no Go files in %s", dir)
+	}
+	return g, nil
+}
+
+// method records the method declaration under the name of its receiver type.
+func (g *generator) method(fn *ast.FuncDecl) {
+	if fn.Recv == nil || len(fn.Recv.List) == 0 {
+		return
+	}
+	recv := fn.Recv.List[0].Type
+	if star, ok := recv.(*ast.StarExpr); ok {
+		recv = star.X
+	}
+	id, ok := recv.(*ast.Ident)
+	if !ok {
+		return
+	}
+	if g.methods[id.Name] == nil {
+		g.methods[id.Name] = make(map[string]bool)
+	}
+	g.methods[id.Name][fn.Name.Name] = true
+}
+
+// fields returns the encoded fields of the struct type sorted by their top
+// level keys, the same way railing.Marshal sorts the pairs.
+func (g *generator) fields(name string) ([]field, error) {
+	spec, ok := g.specs[name]
+	if !ok {
+		return nil, fmt.Errorf("type %s not found", name)
+	}
+	st, ok := spec.Type.(*ast.StructType)
+	if !ok || spec.TypeParams != nil {
+		return nil, fmt.Errorf("type %s is not a struct", name)
+	}
+	var fields []field
+	keys := make(map[string]bool)
+	for _, f := range st.Fields.List {
+		if len(f.Names) == 0 {
+			return nil, fmt.Errorf("%s: embedded fields are not supported", name)
+		}
+		var tag string
+		if f.Tag != nil {
+			s, err := strconv.Unquote(f.Tag.Value)
+			if err != nil {
+				return nil, err
+			}
+			tag = reflect.StructTag(s).Get("railing")
+		}
+		for _, id := range f.Names {
+			if !id.IsExported() || tag == "-" {
+				continue
+			}
+			fd, err := g.field(id.Name, tag, f.Type)
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %v", name, id.Name, err)
+			}
+			if keys[fd.key] {
+				return nil, fmt.Errorf("%s.%s: repeated key %s", name, id.Name,
+					fd.key)
+			}
+			keys[fd.key] = true
+			fields = append(fields, fd)
+		}
+	}
+	sort.SliceStable(fields, func(i, j int) bool {
+		return topKey(fields[i].key) < topKey(fields[j].key)
+	})
+	return fields, nil
+}
+
+// field parses the railing tag and the type of the field.
+func (g *generator) field(name, tag string, expr ast.Expr) (field, error) {
+	f := field{name: name, key: name}
+	opts := strings.Split(tag, ",")
+	if opts[0] != "" {
+		f.key = opts[0]
+	}
+	for _, opt := range opts[1:] {
+		switch opt {
+		case "omitempty":
+			f.omitEmpty = true
+		case "comma":
+			f.comma = true
+		case "form", "spaceDelimited", "pipeDelimited", "deepObject", "explode",
+			"noexplode":
+			return f, fmt.Errorf("option %s is not supported", opt)
+		}
+	}
+	elem := expr
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		elem, f.ptr = t.X, true
+	case *ast.ArrayType:
+		if t.Len != nil {
+			return f, fmt.Errorf("arrays are not supported")
+		}
+		elem, f.kind = t.Elt, slice
+		if star, ok := elem.(*ast.StarExpr); ok {
+			elem, f.ptr = star.X, true
+		}
+	}
+	var err error
+	f.typ, f.basic, f.text, err = g.resolve(elem)
+	if err != nil {
+		return f, err
+	}
+	switch {
+	case f.basic != "" || f.text:
+	case f.kind == slice:
+		f.kind = objects
+	default:
+		f.kind = object
+	}
+	if f.kind == slice && f.ptr {
+		return f, fmt.Errorf("slices of pointers are not supported")
+	}
+	if f.comma && f.kind != slice {
+		return f, fmt.Errorf("comma option is supported only by slices")
+	}
+	return f, nil
+}
+
+// resolve returns the name of the type and the simple type underlying it. The
+// simple type is empty if the type is a struct. It reports whether the type is
+// encoded as text.
+func (g *generator) resolve(expr ast.Expr) (typ, basic string, text bool,
+	err error) {
+	id, ok := expr.(*ast.Ident)
+	if !ok {
+		return "", "", false, fmt.Errorf("type %s is not supported",
+			exprString(expr))
+	}
+	if _, ok := basics[id.Name]; ok {
+		return id.Name, id.Name, false, nil
+	}
+	spec, ok := g.specs[id.Name]
+	if !ok || spec.TypeParams != nil {
+		return "", "", false, fmt.Errorf("type %s is not supported", id.Name)
+	}
+	methods := g.methods[id.Name]
+	text = methods["MarshalText"] && methods["UnmarshalText"]
+	if !text && (methods["MarshalText"] || methods["UnmarshalText"]) {
+		return "", "", false, fmt.Errorf("type %s must implement both "+
+			"MarshalText and UnmarshalText", id.Name)
+	}
+	switch t := spec.Type.(type) {
+	case *ast.StructType:
+		return id.Name, "", text, nil
+	case *ast.Ident:
+		_, basic, _, err = g.resolve(t)
+		if err != nil && text {
+			return id.Name, "len", text, nil
+		}
+		return id.Name, basic, text, err
+	}
+	if text {
+		return id.Name, "len", text, nil
+	}
+	return "", "", false, fmt.Errorf("type %s is not supported", id.Name)
+}
+
+// exprString returns the source of the type expression.
+func exprString(expr ast.Expr) string {
+	var buf bytes.Buffer
+	format.Node(&buf, token.NewFileSet(), expr)
+	return buf.String()
+}
+
+// topKey returns the top level key, eg. "foo" for "foo[][id]".
+func topKey(key string) string {
+	if i := strings.IndexByte(key, '['); i > 0 {
+		return key[:i]
+	}
+	return key
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+// marshal writes MarshalQuery of the type. The fields are added in the order
+// they are sorted, so that the Values are the same as the ones built by
+// railing.Marshal.
+func (g *generator) marshal(name string, fields []field) {
+	g.printf("\n// MarshalQuery implements railing.Marshaler.\n")
+	g.printf("func (v %s) MarshalQuery() (railing.Values, error) {\n", name)
+	g.printf("m := railing.NewValues()\n")
+	for _, f := range fields {
+		x := "v." + f.name
+		switch f.kind {
+		case scalar:
+			switch {
+			case f.ptr:
+				g.printf("if %s != nil {\n", x)
+				if !f.text {
+					x = "*" + x
+				}
+			case f.omitEmpty && f.basic != "":
+				g.printf("if %s {\n", notEmpty(x, f.basic))
+			case f.text:
+				g.printf("{\n")
+			default:
+				g.printf("m.Set(%q, %s)\n", f.key, g.format(x, f))
+				continue
+			}
+			g.printf("m.Set(%q, %s)\n}\n", f.key, g.formatted(x, f))
+		case slice:
+			g.printf("if len(%s) > 0 {\n", x)
+			if f.comma {
+				g.imports["strings"] = true
+				g.printf("strs := make([]string, len(%s))\n", x)
+				g.printf("for i, x := range %s {\n", x)
+				g.printf("strs[i] = %s\n}\n", g.formatted("x", f))
+				g.printf("m.Set(%q, strings.Join(strs, \",\"))\n}\n", f.key)
+				break
+			}
+			g.printf("for _, x := range %s {\n", x)
+			g.printf("m.Add(%q, %s)\n}\n}\n", f.key+"[]", g.formatted("x", f))
+		case object:
+			if f.ptr {
+				g.printf("if %s != nil {\n", x)
+			} else {
+				g.printf("{\n")
+			}
+			g.marshalObject("obj", x, f)
+			g.printf("m.SetObject(%q, obj)\n}\n", f.key)
+		case objects:
+			g.printf("if len(%s) > 0 {\n", x)
+			g.printf("elems := make([]railing.Values, len(%s))\n", x)
+			g.printf("for i := range %s {\n", x)
+			if f.ptr && g.generated[f.typ] {
+				g.printf("if %s[i] == nil {\ncontinue\n}\n", x)
+			}
+			g.marshalObject("elem", x+"[i]", f)
+			g.printf("elems[i] = elem\n}\n")
+			g.printf("m.SetElements(%q, elems)\n}\n", f.key)
+		}
+	}
+	g.printf("return m, nil\n}\n")
+}
+
+// marshalObject writes the statements which marshal the struct x into the
+// variable. The generated types are marshaled by their MarshalQuery, and its
+// error is wrapped the same way railing.Marshal wraps it. Other types are
+// marshaled by railing.Marshal.
+func (g *generator) marshalObject(variable, x string, f field) {
+	if !g.generated[f.typ] {
+		if !f.ptr {
+			x = "&" + x
+		}
+		g.printf("%s, err := railing.Marshal(%s)\n", variable, x)
+		g.printf("if err != nil {\nreturn railing.Values{}, err\n}\n")
+		return
+	}
+	g.imports["reflect"] = true
+	g.printf("%s, err := %s.MarshalQuery()\n", variable, x)
+	g.printf("if err != nil {\nreturn railing.Values{}, "+
+		"&railing.MarshalerError{Type: reflect.TypeOf(%s{}), Err: err}\n}\n",
+		f.typ)
+}
+
+// notEmpty returns the condition which is true if the simple value is not
+// empty.
+func notEmpty(x, basic string) string {
+	switch basic {
+	case "string":
+		return x + ` != ""`
+	case "bool":
+		return x
+	case "len":
+		return "len(" + x + ") > 0"
+	default:
+		return x + " != 0"
+	}
+}
+
+// formatted writes the statements which format the simple value encoded as
+// text, and returns the expression of the formatted value.
+func (g *generator) formatted(x string, f field) string {
+	if !f.text {
+		return g.format(x, f)
+	}
+	g.printf("text, err := %s.MarshalText()\n", x)
+	g.printf("if err != nil {\nreturn railing.Values{}, err\n}\n")
+	return "string(text)"
+}
+
+// format returns the expression which formats the simple value the same way
+// railing.Marshal does it.
+func (g *generator) format(x string, f field) string {
+	switch f.basic {
+	case "string":
+		if f.typ == "string" {
+			return x
+		}
+		return "string(" + x + ")"
+	case "bool":
+		g.imports["strconv"] = true
+		return "strconv.FormatBool(bool(" + x + "))"
+	case "float32", "float64":
+		g.imports["strconv"] = true
+		return "strconv.FormatFloat(float64(" + x + "), 'f', -1, 64)"
+	}
+	g.imports["strconv"] = true
+	if isUnsigned(f.basic) {
+		return "strconv.FormatUint(uint64(" + x + "), 10)"
+	}
+	return "strconv.FormatInt(int64(" + x + "), 10)"
+}
+
+func isUnsigned(basic string) bool {
+	return strings.HasPrefix(basic, "uint") || basic == "byte"
+}
+
+// unmarshal writes UnmarshalQuery of the type. The fields are decoded in the
+// reverse order of their declaration, the same way railing.Unmarshal does it.
+func (g *generator) unmarshal(name string, fields []field) {
+	g.printf("\n// UnmarshalQuery implements railing.Unmarshaler.\n")
+	g.printf("func (v *%s) UnmarshalQuery(m railing.Values) error {\n", name)
+	byDecl := g.declared(name, fields)
+	for _, f := range byDecl {
+		if f.kind == scalar || f.kind == slice {
+			g.printf("var (\nvals []string\nok bool\n)\n")
+			break
+		}
+	}
+	for i := len(byDecl) - 1; i >= 0; i-- {
+		f := byDecl[i]
+		x := "v." + f.name
+		switch f.kind {
+		case scalar:
+			g.lookup(f.key)
+			if f.ptr {
+				g.printf("if vals != nil {\n")
+				g.printf("if %s == nil {\n%s = new(%s)\n}\n", x, x, f.typ)
+				if !f.text {
+					x = "*" + x
+				}
+			}
+			g.printf("if len(vals) > 0 {\ns := vals[len(vals)-1]\n")
+			g.parse(x, f, f.omitEmpty)
+			g.printf("}\n")
+			if f.ptr {
+				g.printf("}\n")
+			}
+		case slice:
+			g.lookup(f.key)
+			g.printf("if vals != nil {\n")
+			if f.comma {
+				g.imports["strings"] = true
+				g.printf("var split []string\n")
+				g.printf("for _, s := range vals {\n")
+				g.printf("split = append(split, strings.Split(s, \",\")...)\n}\n")
+				g.printf("vals = split\n")
+			}
+			g.printf("slice := make([]%s, len(vals))\n", f.typ)
+			g.printf("for i, s := range vals {\n")
+			g.parse("slice[i]", f, false)
+			g.printf("}\n%s = slice\n", x)
+			g.printf("} else if elems, err := m.Elements(%q); err != nil {\n", f.key)
+			g.imports["reflect"] = true
+			g.printf("if e, ok := err.(*railing.UnmarshalTypeError); ok {\n")
+			g.printf("return &railing.UnmarshalTypeError{Value: e.Value, "+
+				"Type: reflect.TypeOf(%s)}\n}\n", x)
+			g.printf("return err\n} else if elems != nil {\n")
+			g.printf("slice := make([]%s, len(elems))\n", f.typ)
+			g.printf("for i, elem := range elems {\n")
+			g.printf("vals, ok := elem.Values[\"\"]\n")
+			g.printf("if !ok || len(elem.Values) != 1 {\n")
+			g.imports["reflect"] = true
+			g.printf("return &railing.UnmarshalTypeError{Value: \"object\", " +
+				"Type: reflect.TypeOf(slice[i])}\n}\n")
+			g.printf("if len(vals) > 0 {\ns := vals[len(vals)-1]\n")
+			g.parse("slice[i]", f, false)
+			g.printf("}\n}\n%s = slice\n}\n", x)
+		case object:
+			g.printf("if obj := m.Object(%q); obj.Values != nil {\n", f.key)
+			g.unmarshalObject("obj", x, f)
+			g.printf("}\n")
+		case objects:
+			typ := f.typ
+			if f.ptr {
+				typ = "*" + typ
+			}
+			g.imports["fmt"] = true
+			g.imports["reflect"] = true
+			g.printf("if elems, err := m.Elements(%q); err != nil {\n", f.key)
+			g.printf("if e, ok := err.(*railing.UnmarshalTypeError); ok {\n")
+			g.printf("return &railing.UnmarshalTypeError{Value: e.Value, "+
+				"Type: reflect.TypeOf(%s)}\n}\n", x)
+			g.printf("return fmt.Errorf(\"%%s. every slice element must "+
+				"contain the same amount of data\", "+
+				"&railing.UnmarshalTypeError{Value: \"object\", "+
+				"Type: reflect.TypeOf(%s)})\n", x)
+			g.printf("} else if elems != nil {\n")
+			g.printf("slice := make([]%s, len(elems))\n", typ)
+			g.printf("for i, elem := range elems {\n")
+			if f.ptr && g.generated[f.typ] {
+				g.printf("slice[i] = new(%s)\n", f.typ)
+				f.ptr = false
+			}
+			g.unmarshalObject("elem", "slice[i]", f)
+			g.printf("}\n%s = slice\n}\n", x)
+		}
+	}
+	g.printf("return nil\n}\n")
+}
+
+// unmarshalObject writes the statements which unmarshal the Values of the
+// variable into the struct x. The generated types are unmarshaled by their
+// UnmarshalQuery, a nil pointer is allocated first the same way
+// railing.Unmarshal does it. Other types are unmarshaled by railing.Unmarshal.
+func (g *generator) unmarshalObject(variable, x string, f field) {
+	if !g.generated[f.typ] {
+		g.printf("if err := railing.Unmarshal(%s, &%s); err != nil {\n",
+			variable, x)
+		g.printf("return err\n}\n")
+		return
+	}
+	if f.ptr {
+		g.printf("if %s == nil {\n%s = new(%s)\n}\n", x, x, f.typ)
+	}
+	g.printf("if err := %s.UnmarshalQuery(%s); err != nil {\n", x, variable)
+	g.printf("return err\n}\n")
+}
+
+// declared returns the fields in the order of their declaration.
+func (g *generator) declared(name string, fields []field) []field {
+	index := make(map[string]int)
+	st := g.specs[name].Type.(*ast.StructType)
+	for _, f := range st.Fields.List {
+		for _, id := range f.Names {
+			index[id.Name] = len(index)
+		}
+	}
+	byDecl := append([]field(nil), fields...)
+	sort.Slice(byDecl, func(i, j int) bool {
+		return index[byDecl[i].name] < index[byDecl[j].name]
+	})
+	return byDecl
+}
+
+// lookup writes the statement which finds the values of the key or of the
+// array under the key, eg. "tags[]" for "tags".
+func (g *generator) lookup(key string) {
+	g.printf("if vals, ok = m.Values[%q]; !ok {\n", key)
+	g.printf("vals = m.Values[%q]\n}\n", key+"[]")
+}
+
+// parse writes the statements which parse s into the simple value x the same
+// way railing.Unmarshal does it. Empty values other than strings are skipped
+// only if the field has omitempty option.
+func (g *generator) parse(x string, f field, omitEmpty bool) {
+	var fn, args, typ string
+	switch {
+	case f.text:
+		if omitEmpty {
+			g.printf("if s != \"\" {\n")
+		}
+		g.printf("if err := %s.UnmarshalText([]byte(s)); err != nil {\n", x)
+		g.printf("return err\n}\n")
+		if omitEmpty {
+			g.printf("}\n")
+		}
+		return
+	case f.basic == "string":
+		if f.typ == "string" {
+			g.printf("%s = s\n", x)
+		} else {
+			g.printf("%s = %s(s)\n", x, f.typ)
+		}
+		return
+	case f.basic == "bool":
+		fn, typ = "ParseBool", "bool "
+	case f.basic == "float32" || f.basic == "float64":
+		fn, args, typ = "ParseFloat", ", "+strconv.Itoa(basics[f.basic]), "number "
+	case isUnsigned(f.basic):
+		fn, args, typ = "ParseUint", ", 10, "+strconv.Itoa(basics[f.basic]), "number "
+	default:
+		fn, args, typ = "ParseInt", ", 10, "+strconv.Itoa(basics[f.basic]), "number "
+	}
+	g.imports["strconv"] = true
+	g.imports["reflect"] = true
+	if omitEmpty {
+		g.printf("if s != \"\" {\n")
+	}
+	g.printf("n, err := strconv.%s(s%s)\n", fn, args)
+	g.printf("if err != nil {\n")
+	g.printf("return &railing.UnmarshalTypeError{Value: %q + s, "+
+		"Type: reflect.TypeOf(%s)}\n}\n", typ, x)
+	g.printf("%s = %s(n)\n", x, f.typ)
+	if omitEmpty {
+		g.printf("}\n")
+	}
+}
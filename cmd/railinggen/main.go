@@ -0,0 +1,82 @@
+//go:build go1.18
+// +build go1.18
+
+// Railinggen generates MarshalQuery and UnmarshalQuery methods for structs, so
+// that they are encoded and decoded without reflection. The methods produce
+// the same Values as railing.Marshal and read them the same way as
+// railing.Unmarshal.
+//
+// Usage:
+//
+//	railinggen -type=T1,T2 [-output=file] [directory]
+//
+// It is meant to be run by go generate:
+//
+//	//go:generate railinggen -type=Order,Address
+//
+// The methods are written to <type>_railing.go, where <type> is the lower-case
+// name of the first type, in the directory of the package. A struct may hold
+// fields of simple types, their pointers and slices, nested structs, their
+// pointers and slices. The types of the package which implement MarshalText
+// and UnmarshalText are simple types. The options of the railing tag other
+// than 'omitempty' and 'comma' are not supported. The methods of the nested
+// structs generated together with the outer one are called directly, other
+// nested structs are encoded and decoded with railing.Marshal and
+// railing.Unmarshal, which use the methods if the nested type has them.
+//
+// The generated methods always encode the fields sorted by their keys, using
+// the Rails syntax for arrays of objects, and decode the last value of a
+// repeated key. The options of the encoder and the decoder, eg. Ordered or
+// RailsBools, do not apply to them, the same way they do not apply to any
+// other Marshaler and Unmarshaler.
+//
+// Railinggen requires Go 1.18 or later. The generated code builds with the
+// same Go versions as the railing package.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	typeNames = flag.String("type", "", "comma-separated list of type names; must be set")
+	output    = flag.String("output", "", "output file name; default <type>_railing.go")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage of railinggen:\n")
+	fmt.Fprintf(os.Stderr, "\trailinggen -type=T1,T2 [-output=file] [directory]\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("railinggen: ")
+	flag.Usage = usage
+	flag.Parse()
+	if *typeNames == "" || flag.NArg() > 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	dir := "."
+	if flag.NArg() == 1 {
+		dir = flag.Arg(0)
+	}
+	types := strings.Split(*typeNames, ",")
+	src, err := generate(dir, types)
+	if err != nil {
+		log.Fatal(err)
+	}
+	name := *output
+	if name == "" {
+		name = filepath.Join(dir, strings.ToLower(types[0])+"_railing.go")
+	}
+	if err := os.WriteFile(name, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
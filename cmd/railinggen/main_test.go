@@ -0,0 +1,62 @@
+//go:build go1.18
+// +build go1.18
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGenerateGolden(t *testing.T) {
+	dir := filepath.Join("..", "..", "internal", "gentest")
+	out, err := generate(dir, []string{"Order", "Address", "Line"})
+	if err != nil {
+		t.Fatalf("expected err=nil; got %v", err)
+	}
+	expected, err := os.ReadFile(filepath.Join(dir, "order_railing.go"))
+	if err != nil {
+		t.Fatalf("expected err=nil; got %v", err)
+	}
+	if !bytes.Equal(out, expected) {
+		t.Errorf("order_railing.go is out of date; run go generate in %s", dir)
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	fixtures := []struct {
+		typ string
+		err string
+	}{
+		// 0
+		{"Missing", "type Missing not found"},
+		// 1
+		{"NotStruct", "type NotStruct is not a struct"},
+		// 2
+		{"Embedded", "Embedded: embedded fields are not supported"},
+		// 3
+		{"Array", "Array.IDs: arrays are not supported"},
+		// 4
+		{"External", "External.At: type time.Time is not supported"},
+		// 5
+		{"Style", "Style.Inner: option form is not supported"},
+		// 6
+		{"Comma", "Comma.ID: comma option is supported only by slices"},
+		// 7
+		{"Repeated", "Repeated.B: repeated key id"},
+		// 8
+		{"Pointers", "Pointers.IDs: slices of pointers are not supported"},
+		// 9
+		{"HalfText", "HalfText.H: type Half must implement both MarshalText " +
+			"and UnmarshalText"},
+	}
+	for i, fixture := range fixtures {
+		_, err := generate(filepath.Join("testdata", "invalid"),
+			[]string{fixture.typ})
+		if err == nil || err.Error() != fixture.err {
+			t.Errorf("expected err=%s; got %v (i=%d)", fixture.err, err, i)
+		}
+	}
+}
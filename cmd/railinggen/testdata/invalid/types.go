@@ -0,0 +1,46 @@
+package invalid
+
+import "time"
+
+type Embedded struct {
+	Inner
+}
+
+type Inner struct {
+	ID int
+}
+
+type Array struct {
+	IDs [2]int
+}
+
+type External struct {
+	At time.Time
+}
+
+type Style struct {
+	Inner Inner `railing:"inner,form"`
+}
+
+type Comma struct {
+	ID int `railing:"id,comma"`
+}
+
+type Repeated struct {
+	A int `railing:"id"`
+	B int `railing:"id"`
+}
+
+type Pointers struct {
+	IDs []*int
+}
+
+type NotStruct int
+
+type Half int
+
+func (Half) MarshalText() ([]byte, error) { return nil, nil }
+
+type HalfText struct {
+	H Half
+}
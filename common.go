@@ -1,76 +1,172 @@
 package railing
 
 import (
+	"encoding"
 	"net/url"
 	"reflect"
-	"regexp"
+	"sort"
 	"strings"
+	"sync"
 )
 
-// reTopKey returns the top key in match[1] and the rest in match[2]
+// splitTop splits the key into its top level segment and the rest of it. It
+// returns false if the key has no top level segment, eg. "[id]".
 //
-// it matches for example:
-//  - foo[][id]  -> [1] = foo, [2] = [][id], [3] = [], [4] = [id]
-//  - foo[id]    -> [1] = foo, [2] = [id],   [3] = ,   [4] = [id]
-//  - foo        -> [1] = foo, [2] = ,       [3] = ,   [4] =
-var reTopKey = regexp.MustCompile(`([^\[]+)((\[\])?(.*))`)
+//   - foo[][id] -> foo, [][id]
+//   - foo[id]   -> foo, [id]
+//   - foo       -> foo,
+func splitTop(key string) (top, rest string, ok bool) {
+	i := strings.IndexByte(key, '[')
+	if i < 0 {
+		return key, "", key != ""
+	}
+	return key[:i], key[i:], i > 0
+}
 
-// reObject helps to detect complex rails style query params objects in
-// url.Values map.
-//
-// it matches for example:
-//  - foo[][id]      -> match[1] = foo, match[2] = id
-//  - foo[id]        -> match[1] = foo, match[2] = id
-//  - foo[bar][name] -> match[1] = foo, match[2] = bar, match[3] = [name]
+// splitObject splits the key of a nested object into its top level segment and
+// the key inside the object. It returns false if the key is not an object, eg.
+// "foo" or "foo[]".
 //
-var reObject = regexp.MustCompile(`([^\[]+)\[(?:\]\[)?([^\]]+)\](.*)`)
+//   - foo[][id]      -> foo, id
+//   - foo[id]        -> foo, id
+//   - foo[bar][name] -> foo, bar[name]
+func splitObject(key string) (top, sub string, ok bool) {
+	top, rest, ok := splitTop(key)
+	if !ok {
+		return "", "", false
+	}
+	if strings.HasPrefix(rest, "[][") {
+		rest = rest[2:]
+	}
+	if !strings.HasPrefix(rest, "[") {
+		return "", "", false
+	}
+	i := strings.IndexByte(rest, ']')
+	if i < 2 {
+		return "", "", false
+	}
+	return top, rest[1:i] + rest[i+1:], true
+}
 
-// findValues searches the url.Values for the given tag. It returns either
-// a subMap or url.Values's value - []string. This depends on the tag. If its
-// a nested struct, or an array.
+// keyIndex indexes the keys of Values by their top level segment. Every nested
+// object gets its own Values with the keys stripped from the top level segment,
+// which are indexed when the object is decoded - this way the index grows into
+// a trie of key segments, level by level, and every key is split only once per
+// level.
 //
-// url.Values{
-//  "normal":         []string{},
-//  "array[]":        []string{},
-//  "nested[object]": []string{},
-// }
+//	Values{                 ->  keyIndex{
+//	  "foo":          ...,  ->    "nested": Values{
+//	  "nested[id]":   ...,  ->      "id":   ...,
+//	  "nested[name]": ...,  ->      "name": ...,
+//	}                       ->    },
+//	                        ->  }
 //
-// The third case - "nested[object]" will return a subMap of all keys which
-// start with "nested".
-func findValues(m url.Values, tag string) (url.Values, []string) {
-	if v, ok := m[tag]; ok {
-		return nil, v
+// If the Values are ordered, the Values of the objects are ordered as well.
+//
+// The keys used by the fields of a struct are recorded in used, rather than
+// deleted from the Values, so that the inline fields which share the index do
+// not use them again.
+type keyIndex struct {
+	m       OrderedValues
+	objects map[string]*OrderedValues
+	used    map[string]bool
+}
+
+func newKeyIndex(m OrderedValues) *keyIndex {
+	idx := &keyIndex{m: m, objects: make(map[string]*OrderedValues)}
+	if m.Ordered() {
+		for _, p := range m.Pairs() {
+			if top, sub, ok := splitObject(p.Key); ok {
+				idx.object(top, true).add(sub, p.Value,
+					p.Value == "" && m.IsNil(p.Key))
+			}
+		}
+		return idx
 	}
-	if v, ok := m[tag+"[]"]; ok {
-		return nil, v
+	for k, vals := range m.Values {
+		if top, sub, ok := splitObject(k); ok {
+			idx.object(top, false).Values[sub] = vals
+		}
 	}
-	if submap := subMap(m, strings.TrimSuffix(tag, "[]")); len(submap) > 0 {
-		return submap, nil
+	return idx
+}
+
+func (idx *keyIndex) object(top string, ordered bool) *OrderedValues {
+	obj, ok := idx.objects[top]
+	if !ok {
+		obj = &OrderedValues{Values: make(url.Values)}
+		if ordered {
+			*obj = NewOrderedValues()
+		}
+		idx.objects[top] = obj
 	}
-	return nil, nil
+	return obj
 }
 
-// subMap returns a sub map of m which contains every key which starts with the
-// given key arg.
-//
-// subMap(m, "nested")
+// find searches the Values for the given tag. It returns either the Values of
+// a nested object or the values of the key - []string. This depends on the tag.
+// If its a nested struct, or an array.
 //
-// url.Values{
-//  "foo":          []string{}  ->  url.Values{
-//  "nested[id]":   []string{}, ->    "id":   []string{},
-//  "nested[name]": []string{}, ->    "name": []string{},
-// }                            ->  }
+//	Values{
+//	  "normal":         []string{},
+//	  "array[]":        []string{},
+//	  "nested[object]": []string{},
+//	}
 //
-func subMap(m url.Values, key string) url.Values {
-	subm := make(url.Values)
-	for k, v := range m {
-		match := reObject.FindStringSubmatch(k)
-		if match == nil || match[1] != key {
-			continue
+// The third case - "nested[object]" will return the Values of the object
+// "nested". The keys which were used are not found.
+func (idx *keyIndex) find(tag string) (OrderedValues, []string) {
+	if v, ok := idx.lookup(tag); ok {
+		return OrderedValues{}, v
+	}
+	if v, ok := idx.lookup(tag + "[]"); ok {
+		return OrderedValues{}, v
+	}
+	if obj, ok := idx.objects[strings.TrimSuffix(tag, "[]")]; ok {
+		return *obj, nil
+	}
+	return OrderedValues{}, nil
+}
+
+// lookup returns the values of the key unless it was used.
+func (idx *keyIndex) lookup(key string) ([]string, bool) {
+	if idx.used[key] {
+		return nil, false
+	}
+	v, ok := idx.m.Values[key]
+	return v, ok
+}
+
+// use records the key as used.
+func (idx *keyIndex) use(key string) {
+	if idx.used == nil {
+		idx.used = make(map[string]bool, len(idx.m.Values))
+	}
+	idx.used[key] = true
+}
+
+// unused returns the Values without the keys which were used. The Values are
+// not copied if no key was used.
+func (idx *keyIndex) unused() OrderedValues {
+	if len(idx.used) == 0 {
+		return idx.m
+	}
+	if !idx.m.Ordered() {
+		m := OrderedValues{Values: make(url.Values, len(idx.m.Values))}
+		for k, vals := range idx.m.Values {
+			if !idx.used[k] {
+				m.Values[k] = vals
+			}
+		}
+		return m
+	}
+	m := NewOrderedValues()
+	for _, p := range idx.m.Pairs() {
+		if !idx.used[p.Key] {
+			m.add(p.Key, p.Value, p.Value == "" && idx.m.IsNil(p.Key))
 		}
-		subm[match[2]+match[3]] = v
 	}
-	return subm
+	return m
 }
 
 // tag describes 'railing' tag and it's options for the given field.
@@ -81,19 +177,44 @@ func subMap(m url.Values, key string) url.Values {
 //             the field will not be shown in encoded Values if it's value is
 //             empty.
 //
-// comma     - is when the tag contains 'comma' option, it means that values
-//             which are slices or arrays will be joined by ',' character.
+// delim     - is the delimiter which joins the values of slices, arrays and
+//             objects which are not exploded. It is ',' for 'comma' option and
+//             for 'form' style with 'noexplode' option, ' ' and '|' for
+//             'spaceDelimited' and 'pipeDelimited' styles.
+//
+// inline    - is true for exploded 'form' style (the default explode of
+//             'form'). Slices and arrays are encoded as repeated keys without
+//             brackets, objects are encoded as if their fields were fields of
+//             the outer struct.
+//
+// layout    - is the layout of time.Time given by 'layout=' option, eg.
+//             'layout=2006-01-02'. The layout cannot contain a comma.
+//
+// unix      - is true when the tag contains 'unix' option. time.Time is
+//             encoded as the number of seconds since the Unix epoch.
+//
+// seconds   - is true when the tag contains 'seconds' option. time.Duration
+//             is encoded as the number of seconds.
+//
+// multiparam - is true when the tag contains 'multiparam' option. time.Time
+//             is encoded as the Rails multiparameter attribute, eg.
+//             "published_at(1i)=2024&published_at(2i)=5".
 //
 // ignore    - is when the tag string is '-'. Such fields are going to be
 //             ignored.
 //
 // empty     - is an internal field, it says if the tag is empty or not.
 type tag struct {
-	name      string
-	omitEmpty bool
-	comma     bool
-	ignore    bool
-	empty     bool
+	name       string
+	omitEmpty  bool
+	delim      string
+	inline     bool
+	layout     string
+	unix       bool
+	seconds    bool
+	multiparam bool
+	ignore     bool
+	empty      bool
 }
 
 func parseTag(field reflect.StructField) (t tag) {
@@ -112,13 +233,174 @@ func parseTag(field reflect.StructField) (t tag) {
 	default:
 		t.name = tags[0]
 	}
+	var style, explode string
 	for _, tagOpt := range tags[1:] {
 		switch tagOpt {
 		case "omitempty":
 			t.omitEmpty = true
 		case "comma":
-			t.comma = true
+			t.delim = ","
+		case "form", "spaceDelimited", "pipeDelimited", "deepObject":
+			style = tagOpt
+		case "explode", "noexplode":
+			explode = tagOpt
+		case "unix":
+			t.unix = true
+		case "seconds":
+			t.seconds = true
+		case "multiparam":
+			t.multiparam = true
+		default:
+			if strings.HasPrefix(tagOpt, "layout=") {
+				t.layout = strings.TrimPrefix(tagOpt, "layout=")
+			}
 		}
 	}
+	if explode == "" && style == "form" {
+		explode = "explode"
+	}
+	switch {
+	case style == "" || style == "deepObject":
+	case explode == "explode":
+		t.inline = true
+	case style == "form":
+		t.delim = ","
+	case style == "spaceDelimited":
+		t.delim = " "
+	case style == "pipeDelimited":
+		t.delim = "|"
+	}
 	return
 }
+
+// isObjectType reports whether values of the type are encoded as objects.
+func isObjectType(typ reflect.Type) bool {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if isTextType(typ) {
+		return false
+	}
+	return typ.Kind() == reflect.Struct || typ.Kind() == reflect.Map
+}
+
+var (
+	textMarshalerType   = reflect.TypeOf(new(encoding.TextMarshaler)).Elem()
+	textUnmarshalerType = reflect.TypeOf(new(encoding.TextUnmarshaler)).Elem()
+)
+
+var textTypes sync.Map // map[reflect.Type]bool
+
+// predeclared holds the predeclared types by their kinds. They have no
+// methods, so they are never encoded as text.
+var predeclared = func() (types [reflect.String + 1]reflect.Type) {
+	for _, v := range []interface{}{false, 0, int8(0), int16(0), int32(0),
+		int64(0), uint(0), uint8(0), uint16(0), uint32(0), uint64(0),
+		uintptr(0), float32(0), float64(0), complex64(0), complex128(0), ""} {
+		typ := reflect.TypeOf(v)
+		types[typ.Kind()] = typ
+	}
+	return
+}()
+
+// isTextType reports whether values of the type are simple values encoded as
+// text - the type or its pointer implements encoding.TextMarshaler or
+// encoding.TextUnmarshaler. The result is cached for every type.
+func isTextType(typ reflect.Type) bool {
+	if k := typ.Kind(); k <= reflect.String && predeclared[k] == typ {
+		return false
+	}
+	if text, ok := textTypes.Load(typ); ok {
+		return text.(bool)
+	}
+	ptr := typ
+	if ptr.Kind() != reflect.Ptr {
+		ptr = reflect.PtrTo(typ)
+	}
+	text := ptr.Implements(textMarshalerType) ||
+		ptr.Implements(textUnmarshalerType)
+	textTypes.Store(typ, text)
+	return text
+}
+
+// field is a compiled struct field. Unexported fields (unless embedded) and
+// fields with the tag "-" are not compiled at all.
+//
+// index    - is the index of the field in the struct.
+//
+// tag      - is the parsed railing tag.
+//
+// embedded - is true for an anonymous struct field with no name in its tag.
+//
+// inline   - is true if the field is decoded from the keys of the outer struct
+//            - it is either anonymous or an exploded form object.
+type field struct {
+	index    int
+	tag      tag
+	embedded bool
+	inline   bool
+}
+
+// structFields is the plan of a struct type. It lists the fields in the order
+// they are encoded and in the order they are decoded - the other fields are
+// decoded in the reverse order and the inline fields are decoded after them.
+// The sorted fields are ordered by their top level keys, the same way the
+// encoded pairs are sorted. The struct is direct if it can be written straight
+// to a query string - it has no inline fields and no repeated names.
+type structFields struct {
+	encode []field
+	decode []field
+	sorted []field
+	direct bool
+}
+
+var fieldCache sync.Map // map[reflect.Type]*structFields
+
+// cachedFields returns the plan of the struct type. It is built once for every
+// type, the same way encoding/json does it.
+func cachedFields(typ reflect.Type) *structFields {
+	if f, ok := fieldCache.Load(typ); ok {
+		return f.(*structFields)
+	}
+	f, _ := fieldCache.LoadOrStore(typ, typeFields(typ))
+	return f.(*structFields)
+}
+
+func typeFields(typ reflect.Type) *structFields {
+	fields := structFields{direct: true}
+	var inline []field
+	names := make(map[string]bool)
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		if sf.PkgPath != "" && !sf.Anonymous { // unexported
+			continue
+		}
+		tag := parseTag(sf)
+		if tag.ignore {
+			continue
+		}
+		f := field{
+			index:    i,
+			tag:      tag,
+			embedded: sf.Anonymous && tag.empty,
+			inline:   sf.Anonymous || tag.inline && isObjectType(sf.Type),
+		}
+		fields.encode = append(fields.encode, f)
+		if f.inline || names[tag.name] {
+			fields.direct = false
+		}
+		names[tag.name] = true
+		if f.inline {
+			inline = append(inline, f)
+		} else {
+			fields.decode = append([]field{f}, fields.decode...)
+		}
+	}
+	fields.decode = append(fields.decode, inline...)
+	fields.sorted = append([]field(nil), fields.encode...)
+	sort.SliceStable(fields.sorted, func(i, j int) bool {
+		return topKey(fields.sorted[i].tag.name) <
+			topKey(fields.sorted[j].tag.name)
+	})
+	return &fields
+}
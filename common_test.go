@@ -0,0 +1,97 @@
+package railing
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestCachedFields(t *testing.T) {
+	type Embedded struct {
+		A int
+	}
+	type s struct {
+		Embedded
+		B      int
+		Color  rgb `railing:"color,form"`
+		C      int `railing:"-"`
+		D      int `railing:"d,omitempty"`
+		unexpo int
+	}
+	fields := cachedFields(reflect.TypeOf(s{}))
+	if cachedFields(reflect.TypeOf(s{})) != fields {
+		t.Error("expected the plan to be cached")
+	}
+	var encode, decode []int
+	for _, f := range fields.encode {
+		encode = append(encode, f.index)
+	}
+	for _, f := range fields.decode {
+		decode = append(decode, f.index)
+	}
+	if expected := []int{0, 1, 2, 4}; !reflect.DeepEqual(encode, expected) {
+		t.Errorf("expected %v; got %v", expected, encode)
+	}
+	if expected := []int{4, 1, 0, 2}; !reflect.DeepEqual(decode, expected) {
+		t.Errorf("expected %v; got %v", expected, decode)
+	}
+	if f := fields.encode[0]; !f.embedded || !f.inline {
+		t.Errorf("expected embedded and inline field; got %+v", f)
+	}
+	if f := fields.encode[2]; f.embedded || !f.inline {
+		t.Errorf("expected inline field; got %+v", f)
+	}
+}
+
+func TestCachedFieldsConcurrent(t *testing.T) {
+	in := orders{Orders: []order{{ID: 1, Items: []int{1, 2}}}}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var out orders
+			m, err := MarshalOrdered(in)
+			if err == nil {
+				err = UnmarshalOrdered(m, &out)
+			}
+			if err != nil {
+				t.Errorf("expected err=nil; got %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestSplitObject(t *testing.T) {
+	fixtures := []struct {
+		in  string
+		top string
+		sub string
+		ok  bool
+	}{
+		// 0
+		{"foo[][id]", "foo", "id", true},
+		// 1
+		{"foo[id]", "foo", "id", true},
+		// 2
+		{"foo[bar][name]", "foo", "bar[name]", true},
+		// 3
+		{"foo[][bar][]", "foo", "bar[]", true},
+		// 4
+		{"foo", "", "", false},
+		// 5
+		{"foo[]", "", "", false},
+		// 6
+		{"foo[][]", "", "", false},
+		// 7
+		{"[id]", "", "", false},
+	}
+	for i, fixture := range fixtures {
+		top, sub, ok := splitObject(fixture.in)
+		if top != fixture.top || sub != fixture.sub || ok != fixture.ok {
+			t.Errorf("expected %s, %s, %v; got %s, %s, %v (i=%d)", fixture.top,
+				fixture.sub, fixture.ok, top, sub, ok, i)
+		}
+	}
+}
@@ -1,9 +1,12 @@
 package railing
 
 import (
+	"encoding"
+	"errors"
 	"fmt"
 	"net/url"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -14,6 +17,11 @@ var errMissingData = func(typ reflect.Type) error {
 		&UnmarshalTypeError{"object", typ})
 }
 
+// errUnevenElements is returned by elements when the keys of an unordered
+// array of objects hold different numbers of values.
+var errUnevenElements = errors.New(
+	"railing: every array element must contain the same amount of data")
+
 // UnmarshalTypeError describes an url.Value's value that was not appropriate
 // for a value of a specific Go type.
 type UnmarshalTypeError struct {
@@ -67,31 +75,175 @@ type Unmarshaler interface {
 // becomes another map[string]interface{}
 //
 // To unmarshal into a map, unmarshal creates a new map where the key must be
-// of a string type and tries to fill the data according to the given type.
+// of a string or an integer type, or implement encoding.TextUnmarshaler, and
+// tries to fill the data according to the given type.
+// The values of the map which are structs, maps, or slices of structs are
+// unmarshaled from the nested keys the same way the fields are, eg.
+// "users[alice][age]=3" into map[string]User or "groups[admins][][name]=a"
+// into map[string][]Member.
 //
 // To unmarshal into a struct, Unmarshal matches incoming keys to the struct's
 // field names or tags. If a field is a slice and tag contains comma option,
-// unmarshal will try to decode the value by splitting it by comma. Fields are
+// unmarshal will try to decode the value by splitting it by comma. The OpenAPI
+// style options are decoded the same way Marshal encodes them. Fields are
 // being unmarshaled before the embedded structs. If an embedded struct contains
 // a field with the same tag as the top level struct then only the top level
 // field will be filled.
 //
-// BUG(jszwec) If the struct contains the array of structs, due to url.Values
-// structure, every element (object) of the array, must contain the same amount
-// of data; if not it is not possible to say where certain elements belong.
+// If the struct contains the array of structs and OrderedValues are decoded by
+// UnmarshalOrdered, eg. the ones returned by ParseQueryOrdered, the elements
+// are separated the same way rack does it - a key which is already set in the
+// last element starts a new one. This way elements can contain different
+// fields, as well as their own nested structs and slices, eg.
+// "orders[][address][city]" or "orders[][items][]".
+//
+// Slices and arrays can also be unmarshaled from objects keyed by indexes, eg.
+// "users[0][name]=a&users[1][name]=b", the way rails' nested attributes forms
+// and jQuery.param send them. The elements are ordered by the index.
+//
+// If the pointer to a simple value implements encoding.TextUnmarshaler, eg.
+// net.IP or big.Int, the value is decoded by its UnmarshalText, also as an
+// element of a slice or a value of a map. Its error is returned as it is.
+//
+// If a key holds many values, eg. "admin=0&admin=1" sent by rails' check_box,
+// the last one is decoded into a simple value, the way rack does it. The
+// Duplicates option of DecoderOptions can choose the first one instead.
+//
+// time.Time and time.Duration are decoded according to the options of the tag
+// the same way Marshal encodes them. time.Duration is decoded both from the Go
+// syntax, eg. "1m30s", and from the number of seconds, eg. "90", with or
+// without the "seconds" option.
+//
+// A time.Time field is also decoded from the Rails multiparameter attribute,
+// eg. "published_at(1i)=2024&published_at(2i)=5&published_at(3i)=17", with
+// or without the "multiparam" option. The hour, minute and second are optional
+// and the time is in UTC. If all the parts are empty, the field is left as it
+// is.
+//
+// BUG(jszwec) If the struct contains the array of structs and the Values are
+// not ordered, due to url.Values structure, every element (object) of the
+// array, must contain the same amount of data; if not it is not possible to say
+// where certain elements belong.
 func Unmarshal(m Values, v interface{}) error {
+	return DecoderOptions{}.Unmarshal(m, v)
+}
+
+// UnmarshalOrdered stores the OrderedValues in the value pointed to by v the
+// same way Unmarshal does it, but the arrays of objects are divided into the
+// elements by the order of the pairs.
+func UnmarshalOrdered(m OrderedValues, v interface{}) error {
+	return DecoderOptions{}.UnmarshalOrdered(m, v)
+}
+
+// DecoderOptions configures the way query strings and Values are decoded. The
+// zero value decodes them the same way ParseQuery and Unmarshal do.
+//
+// The Max options limit the size of the decoded params, so that the query
+// strings sent by untrusted clients cannot exhaust the memory. A LimitError is
+// returned when any of them is exceeded. The limits which are zero are not
+// enforced.
+type DecoderOptions struct {
+	// Dialect is the syntax of the parsed query strings. It defaults to Rails.
+	Dialect Dialect
+
+	// Duplicates decides which of the values of a repeated key is decoded into
+	// a simple value, eg. "admin=0&admin=1" sent by rails' check_box. It
+	// defaults to LastValue, the way rack resolves them.
+	Duplicates Duplicates
+
+	// RailsBools makes bools decoded the way rails casts them, ignoring the
+	// case: "1", "t", "true", "on" and "yes" are true, "0", "f", "false", "off",
+	// "no" and "" are false.
+	RailsBools bool
+
+	// BareNils makes ParseQuery parse the keys without '=', eg. "name", as nil
+	// keys of Values, the way rack parses them as nil, and Unmarshal decode nil
+	// keys into nil pointers and interfaces, or zero values of other types. By
+	// default, such keys have empty values.
+	BareNils bool
+
+	// EmptyArrays makes an array with a single empty value, eg. "tags[]=" or
+	// "tags[]", decoded into an empty slice which is not nil, the way rails
+	// treats an explicitly empty array after deep_munge. By default, it is
+	// decoded into a slice holding the zero value.
+	EmptyArrays bool
+
+	// MaxDepth limits the nesting of the keys - the number of their brackets,
+	// eg. "a[b][]" is nested twice, the way rack's param_depth_limit does it.
+	MaxDepth int
+
+	// MaxKeys limits the number of params of the query string or the number of
+	// values of the unmarshaled Values.
+	MaxKeys int
+
+	// MaxArrayLength limits the number of elements of the arrays, including the
+	// values split by the delimiter of the tag and the elements of the arrays
+	// of objects.
+	MaxArrayLength int
+
+	// MaxValueSize limits the size of the unescaped values in bytes. The
+	// query string is read in chunks limited by it, so that a param which is
+	// longer than the escaped value of this size, together with a key of up
+	// to 64 KiB, is rejected without being read as a whole.
+	MaxValueSize int
+}
+
+// Duplicates decides which of the values of a repeated key is decoded into a
+// simple value.
+type Duplicates int
+
+const (
+	// LastValue decodes the last value of the key, the way rack does it.
+	LastValue Duplicates = iota
+
+	// FirstValue decodes the first value of the key, the way url.Values.Get
+	// does it.
+	FirstValue
+)
+
+// Unmarshal stores the Values in the value pointed to by v according to the
+// options. See Unmarshal function for details.
+func (o DecoderOptions) Unmarshal(m Values, v interface{}) error {
+	return o.UnmarshalOrdered(OrderedValues{Values: m.Values}, v)
+}
+
+// UnmarshalOrdered stores the OrderedValues in the value pointed to by v
+// according to the options. See UnmarshalOrdered function for details.
+func (o DecoderOptions) UnmarshalOrdered(m OrderedValues, v interface{}) error {
 	rv := reflect.ValueOf(v)
 	if rv.Kind() != reflect.Ptr || rv.IsNil() {
 		return &InvalidUnmarshalError{reflect.TypeOf(v)}
 	}
-	mcopy := make(url.Values)
-	for k, v := range m.Values {
-		mcopy[k] = v
+	if err := o.checkValues(m); err != nil {
+		return err
+	}
+	return o.decoder().unmarshal(m.clone(), rv)
+}
+
+func (o DecoderOptions) decoder() *decoder {
+	return &decoder{
+		duplicates:     o.Duplicates,
+		railsBools:     o.RailsBools,
+		bareNils:       o.BareNils,
+		emptyArrays:    o.EmptyArrays,
+		maxArrayLength: o.MaxArrayLength,
+	}
+}
+
+func (o DecoderOptions) dialect() Dialect {
+	if o.Dialect == nil {
+		return Rails
 	}
-	return (&decoder{}).unmarshal(mcopy, rv)
+	return o.Dialect
 }
 
-type decoder struct{}
+type decoder struct {
+	duplicates     Duplicates
+	railsBools     bool
+	bareNils       bool
+	emptyArrays    bool
+	maxArrayLength int
+}
 
 // indirect walks down v allocating pointers as needed, until it gets to a
 // non-pointer. if it encounters an Unmarshaler, indirect stops and returns it.
@@ -139,46 +291,138 @@ func (d *decoder) indirect(v reflect.Value) (Unmarshaler, reflect.Value) {
 func (d *decoder) objectInterface(values url.Values) map[string]interface{} {
 	m := make(map[string]interface{})
 	for k, v := range values {
-		match := reObject.FindStringSubmatch(k)
-		if match != nil {
-			m[match[1]] = d.objectInterface(subMap(values, match[1]))
-			continue
+		if _, _, ok := splitObject(k); !ok {
+			m[strings.TrimSuffix(k, "[]")] = v
 		}
-		m[strings.TrimSuffix(k, "[]")] = v
+	}
+	for k, obj := range newKeyIndex(OrderedValues{Values: values}).objects {
+		m[k] = d.objectInterface(obj.Values)
 	}
 	return m
 }
 
-// maps builds a map of the given type filling it with the data from url.Values.
-// Nested keys remain as they are unless the map type is map[string]interface{}.
-// Any array key eg. "array[]" will be stripped from "[]".
-func (d *decoder) maps(values url.Values, v reflect.Value) error {
+// maps builds a map of the given type filling it with the data from Values.
+// Any array key eg. "array[]" will be stripped from "[]". The keys are parsed
+// into the key type of the map, see parseMapKey. With BareNils, a key without a
+// value gets the zero value, eg. a nil pointer, the same way a field does.
+//
+// If the values of the map are structs, maps, or slices or arrays of structs,
+// the nested keys are decoded into them recursively, eg. "alice[age]" is the
+// key "age" of the value under "alice", and "admins[][name]" is the key "name"
+// of the elements of the slice under "admins". Otherwise, the nested keys
+// remain as they are unless the map type is map[string]interface{}.
+func (d *decoder) maps(values OrderedValues, v reflect.Value) error {
 	if v.Type() == reflect.TypeOf(map[string]interface{}{}) {
-		v.Set(reflect.ValueOf(d.objectInterface(values)))
+		v.Set(reflect.ValueOf(d.objectInterface(values.Values)))
 		return nil
 	}
 	typ := v.Type()
-	if typ.Key().Kind() != reflect.String {
+	if !isMapKeyType(typ.Key()) {
 		return &UnmarshalTypeError{"object", typ}
 	}
 	m := reflect.MakeMap(typ)
-	for k, values := range values {
+	nested := isNestedType(typ.Elem())
+	for k, vals := range values.Values {
+		if _, _, ok := splitObject(k); ok && nested {
+			continue
+		}
+		k = strings.TrimSuffix(k, "[]")
+		key, err := parseMapKey(k, typ.Key())
+		if err != nil {
+			return err
+		}
 		newVal := reflect.Indirect(reflect.New(typ.Elem()))
-		if err := d.conv(values, newVal, false); err != nil {
+		if len(vals) == 0 && d.bareNils {
+			m.SetMapIndex(key, newVal)
+			continue
+		}
+		if err := d.conv(vals, newVal, tag{name: k}); err != nil {
 			return err
 		}
-		m.SetMapIndex(reflect.ValueOf(strings.TrimSuffix(k, "[]")), newVal)
+		m.SetMapIndex(key, newVal)
+	}
+	if nested {
+		for k, obj := range newKeyIndex(values).objects {
+			key, err := parseMapKey(k, typ.Key())
+			if err != nil {
+				return err
+			}
+			newVal := reflect.Indirect(reflect.New(typ.Elem()))
+			switch newVal.Kind() {
+			case reflect.Slice, reflect.Array:
+				err = d.indexedObject(*obj, newVal, tag{name: k})
+			default:
+				err = d.unmarshal(*obj, newVal)
+			}
+			if err != nil {
+				return err
+			}
+			m.SetMapIndex(key, newVal)
+		}
 	}
 	v.Set(m)
 	return nil
 }
 
+// isMapKeyType reports whether the keys of the type can be parsed by
+// parseMapKey.
+func isMapKeyType(typ reflect.Type) bool {
+	switch kind := typ.Kind(); {
+	case kind == reflect.String, kind >= reflect.Int && kind <= reflect.Uintptr:
+		return true
+	}
+	return reflect.PtrTo(typ).Implements(textUnmarshalerType)
+}
+
+// parseMapKey parses the key of a map, the same way encoding/json does it. If
+// the pointer to the key type implements encoding.TextUnmarshaler, the key is
+// decoded by its UnmarshalText, and its error is returned as it is. Otherwise,
+// the key is either a string or an integer in base 10, eg. "prices[42]".
+func parseMapKey(s string, typ reflect.Type) (reflect.Value, error) {
+	key := reflect.New(typ)
+	if u, ok := key.Interface().(encoding.TextUnmarshaler); ok {
+		return key.Elem(), u.UnmarshalText([]byte(s))
+	}
+	key = key.Elem()
+	switch kind := typ.Kind(); {
+	case kind == reflect.String:
+		key.SetString(s)
+	case kind <= reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, typ.Bits())
+		if err != nil {
+			return reflect.Value{}, &UnmarshalTypeError{"number " + s, typ}
+		}
+		key.SetInt(n)
+	default:
+		n, err := strconv.ParseUint(s, 10, typ.Bits())
+		if err != nil {
+			return reflect.Value{}, &UnmarshalTypeError{"number " + s, typ}
+		}
+		key.SetUint(n)
+	}
+	return key, nil
+}
+
+// isNestedType reports whether the values of the type are decoded from nested
+// keys - they are objects, or slices or arrays of structs.
+func isNestedType(typ reflect.Type) bool {
+	switch typ.Kind() {
+	case reflect.Slice, reflect.Array:
+		return isStructSlice(typ)
+	default:
+		return isObjectType(typ)
+	}
+}
+
 // slice builds a slice of the given type and attempts to translate the data
 // from value arg.
-func (d *decoder) slice(value []string, v reflect.Value) error {
+func (d *decoder) slice(value []string, v reflect.Value, tag tag) error {
+	if err := d.checkLength(tag.name, len(value)); err != nil {
+		return err
+	}
 	slice := reflect.MakeSlice(v.Type(), len(value), len(value))
 	for i := 0; i < len(value); i++ {
-		if err := d.conv([]string{value[i]}, slice.Index(i), false); err != nil {
+		if err := d.conv([]string{value[i]}, slice.Index(i), tag); err != nil {
 			return err
 		}
 	}
@@ -188,11 +432,14 @@ func (d *decoder) slice(value []string, v reflect.Value) error {
 
 // array builds a slice of the given type and attempts to translate the data
 // from value arg and then copies it to the given array.
-func (d *decoder) array(value []string, v reflect.Value) error {
+func (d *decoder) array(value []string, v reflect.Value, tag tag) error {
+	if err := d.checkLength(tag.name, len(value)); err != nil {
+		return err
+	}
 	slice := reflect.MakeSlice(
 		reflect.SliceOf(v.Type().Elem()), len(value), len(value))
 	for i := 0; i < len(value); i++ {
-		if err := d.conv([]string{value[i]}, slice.Index(i), false); err != nil {
+		if err := d.conv([]string{value[i]}, slice.Index(i), tag); err != nil {
 			return err
 		}
 	}
@@ -200,14 +447,37 @@ func (d *decoder) array(value []string, v reflect.Value) error {
 	return nil
 }
 
-// conv attempts to convert a single url.Value's value to the v's type.
-func (d *decoder) conv(value []string, v reflect.Value, omitempty bool) error {
+// conv attempts to convert a single url.Value's value to the v's type. If v's
+// pointer implements encoding.TextUnmarshaler, the value is decoded by its
+// UnmarshalText. time.Time and time.Duration are parsed according to the
+// options of the tag. Empty values are skipped if the tag has omitempty option.
+// If the key has many values, the one chosen by the Duplicates option is used.
+func (d *decoder) conv(value []string, v reflect.Value, tag tag) error {
+	omitempty := tag.omitEmpty
+	var s string
+	if len(value) >= 1 {
+		s = d.scalar(value)
+	}
+	if typ := v.Type(); typ == timeType || typ == durationType {
+		if len(value) < 1 || s == "" && omitempty {
+			return nil
+		}
+		if ok, err := parseTime(s, v, tag); ok {
+			return err
+		}
+	}
+	if u := textUnmarshaler(v); u != nil {
+		if len(value) >= 1 && !(s == "" && omitempty) {
+			return u.UnmarshalText([]byte(s))
+		}
+		return nil
+	}
 	switch v.Kind() {
 	case reflect.Ptr:
 		if v.IsNil() {
 			v.Set(reflect.New(v.Type().Elem()))
 		}
-		return d.conv(value, v.Elem(), omitempty)
+		return d.conv(value, v.Elem(), tag)
 	case reflect.Interface:
 		if v.NumMethod() == 0 {
 			v.Set(reflect.ValueOf(value))
@@ -216,47 +486,53 @@ func (d *decoder) conv(value []string, v reflect.Value, omitempty bool) error {
 		}
 		return nil
 	case reflect.Slice:
-		return d.slice(value, v)
+		if d.emptyArrays && len(value) == 1 && s == "" {
+			v.Set(reflect.MakeSlice(v.Type(), 0, 0))
+			return nil
+		}
+		tag.omitEmpty = false
+		return d.slice(value, v, tag)
 	case reflect.Array:
-		return d.array(value, v)
+		tag.omitEmpty = false
+		return d.array(value, v, tag)
 	case reflect.String:
 		if len(value) >= 1 {
-			v.SetString(value[0])
+			v.SetString(s)
 		}
 		return nil
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		if len(value) >= 1 && value[0] != "" {
-			n, err := strconv.ParseInt(value[0], 10, 64)
+		if len(value) >= 1 && !(s == "" && omitempty) {
+			n, err := strconv.ParseInt(s, 10, 64)
 			if err != nil || v.OverflowInt(n) {
-				return &UnmarshalTypeError{"number " + value[0], v.Type()}
+				return &UnmarshalTypeError{"number " + s, v.Type()}
 			}
 			v.SetInt(n)
 		}
 		return nil
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
 		reflect.Uint64:
-		if len(value) >= 1 && value[0] != "" {
-			n, err := strconv.ParseUint(value[0], 10, 64)
+		if len(value) >= 1 && !(s == "" && omitempty) {
+			n, err := strconv.ParseUint(s, 10, 64)
 			if err != nil || v.OverflowUint(n) {
-				return &UnmarshalTypeError{"number " + value[0], v.Type()}
+				return &UnmarshalTypeError{"number " + s, v.Type()}
 			}
 			v.SetUint(n)
 		}
 		return nil
 	case reflect.Float32, reflect.Float64:
-		if len(value) >= 1 && !(value[0] == "" && omitempty) {
-			n, err := strconv.ParseFloat(value[0], v.Type().Bits())
+		if len(value) >= 1 && !(s == "" && omitempty) {
+			n, err := strconv.ParseFloat(s, v.Type().Bits())
 			if err != nil || v.OverflowFloat(n) {
-				return &UnmarshalTypeError{"number " + value[0], v.Type()}
+				return &UnmarshalTypeError{"number " + s, v.Type()}
 			}
 			v.SetFloat(n)
 		}
 		return nil
 	case reflect.Bool:
-		if len(value) >= 1 && !(value[0] == "" && omitempty) {
-			b, err := strconv.ParseBool(value[0])
+		if len(value) >= 1 && !(s == "" && omitempty) {
+			b, err := d.parseBool(s)
 			if err != nil {
-				return &UnmarshalTypeError{"bool " + value[0], v.Type()}
+				return &UnmarshalTypeError{"bool " + s, v.Type()}
 			}
 			v.SetBool(b)
 		}
@@ -265,13 +541,52 @@ func (d *decoder) conv(value []string, v reflect.Value, omitempty bool) error {
 	return &UnsupportedTypeError{v.Type()}
 }
 
-func (d *decoder) unmarshal(values url.Values, v reflect.Value) error {
+// scalar returns the value decoded into a simple value out of the values of a
+// key, according to the Duplicates option. The values cannot be empty.
+func (d *decoder) scalar(value []string) string {
+	if d.duplicates == FirstValue {
+		return value[0]
+	}
+	return value[len(value)-1]
+}
+
+// railsBools holds the values rails casts to bools, in lower case.
+var railsBools = map[string]bool{
+	"1": true, "t": true, "true": true, "on": true, "yes": true,
+	"0": false, "f": false, "false": false, "off": false, "no": false, "": false,
+}
+
+// parseBool parses the bool the way strconv.ParseBool does it, or the way rails
+// casts it if the RailsBools option is set.
+func (d *decoder) parseBool(s string) (bool, error) {
+	if !d.railsBools {
+		return strconv.ParseBool(s)
+	}
+	if b, ok := railsBools[strings.ToLower(s)]; ok {
+		return b, nil
+	}
+	return false, strconv.ErrSyntax
+}
+
+// textUnmarshaler returns the pointer to v as encoding.TextUnmarshaler if it
+// implements it. Otherwise, it returns nil.
+func textUnmarshaler(v reflect.Value) encoding.TextUnmarshaler {
+	if v.Kind() == reflect.Ptr || !v.CanAddr() || !isTextType(v.Type()) {
+		return nil
+	}
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u
+	}
+	return nil
+}
+
+func (d *decoder) unmarshal(values OrderedValues, v reflect.Value) error {
 	u, v := d.indirect(v)
 	if u != nil {
-		return u.UnmarshalQuery(Values{values})
+		return u.UnmarshalQuery(Values{values.Values})
 	}
 	if v.Kind() == reflect.Interface && v.NumMethod() == 0 {
-		v.Set(reflect.ValueOf(d.objectInterface(values)))
+		v.Set(reflect.ValueOf(d.objectInterface(values.Values)))
 		return nil
 	}
 	switch v.Kind() {
@@ -284,36 +599,19 @@ func (d *decoder) unmarshal(values url.Values, v reflect.Value) error {
 	}
 }
 
-// fields returns a slice of fields indexes which are ordered in such a way
-// that embedded fields are at the end. It returns indexes only of the exported
-// fields.
-func (d *decoder) fields(typ reflect.Type) (indexes []int) {
-	for i := 0; i < typ.NumField(); i++ {
-		field := typ.Field(i)
-		if field.PkgPath != "" && !field.Anonymous { // unexported
-			continue
-		}
-		if field.Anonymous {
-			indexes = append(indexes, i)
-		} else {
-			indexes = append([]int{i}, indexes...)
-		}
-	}
-	return
-}
-
 // indexedObject attempts to unmarshal the data in m to the slice or array of
 // structs under v.
-func (d *decoder) indexedObject(m url.Values, v reflect.Value) error {
+func (d *decoder) indexedObject(m OrderedValues, v reflect.Value,
+	tag tag) error {
 	switch v.Kind() {
 	case reflect.Array:
-		slice, err := d.sliceObject(m, reflect.SliceOf(v.Type().Elem()))
+		slice, err := d.sliceObject(m, reflect.SliceOf(v.Type().Elem()), tag)
 		if err != nil {
 			return err
 		}
 		reflect.Copy(v, slice)
 	case reflect.Slice:
-		slice, err := d.sliceObject(m, v.Type())
+		slice, err := d.sliceObject(m, v.Type(), tag)
 		if err != nil {
 			return err
 		}
@@ -323,7 +621,8 @@ func (d *decoder) indexedObject(m url.Values, v reflect.Value) error {
 }
 
 // sliceObject is used to unmarshal an array of structs. It returns a slice of
-// structs filled with the data from m. First it iterates m to check if every
+// structs filled with the data from m. If m is ordered, the elements are
+// separated by orderedElements. Otherwise, first it iterates m to check if every
 // value is of the same length. It is necessary that the data is complete and
 // every key contains the same data. We divide the data in the map by index.
 //
@@ -338,43 +637,188 @@ func (d *decoder) indexedObject(m url.Values, v reflect.Value) error {
 // Element 1: {"a", "1"}
 // Element 2: {"b", "2"}
 //
-// If the caller wants an array as part of the element, then the best workaround
-// would be to make sure that the array is being sent as a string separated
-// with some character, and then implement a type with custom Unmarshaler
-// to handle it or use comma tag. Look at examples.
-func (d *decoder) sliceObject(m url.Values,
-	typ reflect.Type) (reflect.Value, error) {
+// Every element is unmarshaled from its own Values, eg. "items[]" and
+// "address[city]" keys of the element fill the Items slice and the Address
+// struct, the same way they would do it at the top level.
+//
+// If m is not ordered and the caller wants an array as part of the element,
+// then the best workaround would be to make sure that the array is being sent
+// as a string separated with some character, and then implement a type with
+// custom Unmarshaler to handle it or use comma tag. Look at examples.
+func (d *decoder) sliceObject(m OrderedValues, typ reflect.Type,
+	tag tag) (reflect.Value, error) {
+	elems, err := d.elements(m, tag.name, typ)
+	if err == errUnevenElements {
+		return reflect.Value{}, errMissingData(typ)
+	}
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	slice := reflect.MakeSlice(typ, len(elems), len(elems))
+	tag.omitEmpty = false
+	for i, elem := range elems {
+		if value, ok := elem.Values[""]; ok && len(elem.Values) == 1 {
+			if err := d.conv(value, slice.Index(i), tag); err != nil {
+				return reflect.Value{}, err
+			}
+			continue
+		}
+		if err := d.unmarshal(elem, slice.Index(i)); err != nil {
+			return reflect.Value{}, err
+		}
+	}
+	return slice, nil
+}
+
+// elements divides m into the elements of the array under the key. The
+// elements are counted while they are divided, so that LimitError is returned
+// as soon as there are more of them than MaxArrayLength. The type of the array
+// is reported by UnmarshalTypeError if an index of the elements is invalid.
+func (d *decoder) elements(m OrderedValues, key string,
+	typ reflect.Type) ([]OrderedValues, error) {
+	if elems, ok, err := d.indexedElements(m, key, typ); ok || err != nil {
+		return elems, err
+	}
+	if m.Ordered() {
+		return d.orderedElements(m, key)
+	}
 	l := 0
 	keys := []string{}
-	for k, vv := range m {
+	for k, vv := range m.Values {
 		keys = append(keys, k)
 		if l == 0 {
 			l = len(vv)
 			continue
 		}
 		if len(vv) != l {
-			return reflect.Value{}, errMissingData(typ)
+			return nil, errUnevenElements
 		}
 	}
-	slice := reflect.MakeSlice(typ, l, l)
-	for i := 0; i < l; i++ {
-		mm := make(url.Values)
+	if err := d.checkLength(key, l); err != nil {
+		return nil, err
+	}
+	elems := make([]OrderedValues, l)
+	for i := range elems {
+		elems[i] = OrderedValues{Values: make(url.Values)}
 		for _, key := range keys {
-			mm.Set(key, m[key][i])
+			elems[i].Set(key, m.Values[key][i])
 		}
-		if err := d.unmarshal(mm, slice.Index(i)); err != nil {
-			return reflect.Value{}, err
+	}
+	return elems, nil
+}
+
+// indexedElements divides m, which keys start with an index, into the elements
+// of an array ordered by the index. This is how rails' nested attributes and
+// jQuery.param send arrays of objects. The element's keys are stripped from
+// the index, a key which is the index itself becomes an empty key.
+//
+// 0[name]=a, 1[name]=b, 1[tags][]=x -> Element 1: name=a
+//                                      Element 2: name=b, tags[]=x
+//
+// It returns false if any of the keys does not start with an index. A negative
+// index or one which overflows int is an UnmarshalTypeError. An index which is
+// not less than MaxArrayLength is a LimitError, the same way a longer array
+// is.
+func (d *decoder) indexedElements(m OrderedValues, key string,
+	typ reflect.Type) ([]OrderedValues, bool, error) {
+	pairs := m.Pairs()
+	if len(pairs) == 0 {
+		return nil, false, nil
+	}
+	byIndex := make(map[int]*OrderedValues)
+	indexes := []int{}
+	for _, p := range pairs {
+		index, sub, ok := splitIndex(p.Key)
+		if !ok {
+			return nil, false, nil
+		}
+		i, err := strconv.Atoi(index)
+		if err != nil || i < 0 {
+			return nil, false, &UnmarshalTypeError{"index " + index, typ}
+		}
+		if err := d.checkLength(key, i+1); err != nil {
+			return nil, false, err
 		}
+		elem, ok := byIndex[i]
+		if !ok {
+			v := NewOrderedValues()
+			elem = &v
+			byIndex[i] = elem
+			indexes = append(indexes, i)
+		}
+		elem.Add(sub, p.Value)
 	}
-	return slice, nil
+	sort.Ints(indexes)
+	elems := make([]OrderedValues, len(indexes))
+	for j, i := range indexes {
+		elems[j] = *byIndex[i]
+	}
+	return elems, true, nil
+}
+
+// splitIndex splits the key into the index and the rest of the key, eg.
+// "0[name][first]" -> "0", "name[first]". It returns false if the key does not
+// start with an index - an integer, which may be negative or too big for int.
+func splitIndex(key string) (string, string, bool) {
+	top, rest := key, ""
+	if i := strings.IndexByte(key, '['); i >= 0 {
+		top, rest = key[:i], key[i:]
+	}
+	digits := strings.TrimPrefix(top, "-")
+	if digits == "" || strings.TrimLeft(digits, "0123456789") != "" {
+		return "", "", false
+	}
+	if j := strings.IndexByte(rest, ']'); j > 0 {
+		rest = rest[1:j] + rest[j+1:]
+	}
+	return top, rest, true
+}
+
+// orderedElements divides ordered m into the elements of an array following
+// rack's rules - a pair belongs to the last element unless the element already
+// has its key, then it starts a new element. The pairs of the example belong
+// to two elements:
+//
+// id=1, name=a, tags[]=x, tags[]=y, id=2
+func (d *decoder) orderedElements(m OrderedValues,
+	key string) ([]OrderedValues, error) {
+	p := parser{root: NewHashNode()}
+	for _, pair := range m.Pairs() {
+		name := "[][" + pair.Key + "]"
+		if i := strings.IndexByte(pair.Key, '['); i > 0 {
+			name = "[][" + pair.Key[:i] + "]" + pair.Key[i:]
+		}
+		if _, err := p.normalize(p.root, "elems"+name, pair.Value,
+			0); err != nil {
+			return nil, err
+		}
+		if arr := p.root.Get("elems"); arr != nil {
+			if err := d.checkLength(key, arr.Len()); err != nil {
+				return nil, err
+			}
+		}
+	}
+	arr := p.root.Get("elems")
+	if arr == nil {
+		return nil, nil
+	}
+	elems := make([]OrderedValues, 0, arr.Len())
+	for _, elem := range arr.elems {
+		v, err := FromTree(elem)
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, v)
+	}
+	return elems, nil
 }
 
 // object is used to unmarshal into a struct. It iterates over ordered fields
 // and unmarshals, first the normal fields and then embedded fields.
 //
 // If the field is embedded, then unmarshal starts over again with the same
-// url.Values. However, the keys which were already used are no longer in the
-// map - every key should be used only once.
+// url.Values. However, the keys which were already used are recorded by the
+// index of the keys and skipped - every key should be used only once.
 //
 // If the key in a map is a nested struct then the sub-map is being created in
 // order to unmarshal it. For example - "foo[name]" key will turn into "name" in
@@ -385,57 +829,101 @@ func (d *decoder) sliceObject(m url.Values,
 //
 // If the type implements Unmarshaler interface then UnmarshalQuery will be
 // used instead of conv function.
-func (d *decoder) object(m url.Values, v reflect.Value) (err error) {
-	typ := v.Type()
-	for _, i := range d.fields(typ) {
-		fieldType := typ.Field(i)
-		tag := parseTag(fieldType)
-		if tag.ignore {
-			continue
-		}
-		v := v.Field(i)
-		if fieldType.Anonymous {
-			if err := d.unmarshal(m, v); err != nil {
+func (d *decoder) object(m OrderedValues, v reflect.Value) (err error) {
+	return d.fields(newKeyIndex(m), v)
+}
+
+// fields unmarshals the fields of the struct under v from the indexed keys.
+func (d *decoder) fields(idx *keyIndex, v reflect.Value) error {
+	for _, f := range cachedFields(v.Type()).decode {
+		v := v.Field(f.index)
+		if f.inline {
+			if err := d.inline(idx, v); err != nil {
 				return err
 			}
 			continue
 		}
-		subm, values := findValues(m, tag.name)
-		if subm != nil {
-			switch v.Kind() {
-			case reflect.Slice, reflect.Array:
-				if err := d.indexedObject(subm, v); err != nil {
-					return err
-				}
-			default:
-				if err := d.unmarshal(subm, v); err != nil {
-					return err
-				}
-			}
-			continue
+		if err := d.field(idx, f.tag, v); err != nil {
+			return err
 		}
-		if values != nil {
-			u, v := d.indirect(v)
-			if u != nil {
-				if err := u.UnmarshalQuery(Values{m}); err != nil {
-					return err
-				}
-				continue
-			} else {
-				if tag.comma {
-					values = d.splitValues(values, ",")
-				}
-				if err := d.conv(values, v, tag.omitEmpty); err != nil {
-					return err
-				}
-			}
-			delete(m, tag.name)
-			continue
+	}
+	return nil
+}
+
+// inline unmarshals the inline field from the keys of the outer struct which
+// were not used yet. A struct shares the index of the keys with the outer one,
+// other types get the Values without the used keys.
+func (d *decoder) inline(idx *keyIndex, v reflect.Value) error {
+	if u, sv := d.indirect(v); u == nil && sv.Kind() == reflect.Struct {
+		return d.fields(idx, sv)
+	}
+	return d.unmarshal(idx.unused(), v)
+}
+
+// field unmarshals the value of the key named by the tag into v. It does
+// nothing if the index holds no such key. The key is recorded as used once its
+// value is used, so that the inline fields do not use it again.
+func (d *decoder) field(idx *keyIndex, tag tag, v reflect.Value) error {
+	subm, values := idx.find(tag.name)
+	if subm.Values != nil {
+		switch v.Kind() {
+		case reflect.Slice, reflect.Array:
+			return d.indexedObject(subm, v, tag)
+		default:
+			return d.unmarshal(subm, v)
 		}
 	}
+	if values == nil {
+		return d.multiparam(idx, tag, v)
+	}
+	if len(values) == 0 && d.bareNils {
+		v.Set(reflect.Zero(v.Type()))
+		idx.use(tag.name)
+		return nil
+	}
+	u, v := d.indirect(v)
+	if u != nil {
+		return u.UnmarshalQuery(Values{idx.unused().Values})
+	}
+	if tag.delim != "" && isObjectType(v.Type()) {
+		if err := d.splitObject(values, v, tag.delim); err != nil {
+			return err
+		}
+		idx.use(tag.name)
+		return nil
+	}
+	if tag.delim != "" {
+		values = d.splitValues(values, tag.delim)
+	}
+	if err := d.conv(values, v, tag); err != nil {
+		return err
+	}
+	idx.use(tag.name)
 	return nil
 }
 
+// splitObject unmarshals the object which keys and values are joined by the
+// delimiter, eg. "color=R,100,G,200".
+func (d *decoder) splitObject(values []string, v reflect.Value,
+	sep string) error {
+	var strs []string
+	var value string
+	if len(values) > 0 {
+		value = d.scalar(values)
+	}
+	if value != "" {
+		strs = strings.Split(value, sep)
+	}
+	if len(strs)%2 != 0 {
+		return &UnmarshalTypeError{"object " + value, v.Type()}
+	}
+	m := NewOrderedValues()
+	for i := 0; i < len(strs); i += 2 {
+		m.Add(strs[i], strs[i+1])
+	}
+	return d.unmarshal(m, v)
+}
+
 func (d *decoder) splitValues(values []string, sep string) (res []string) {
 	for _, v := range values {
 		res = append(res, strings.Split(v, sep)...)
@@ -2,11 +2,15 @@ package railing
 
 import (
 	"database/sql"
+	"errors"
+	"math/big"
+	"net"
 	"net/url"
 	"reflect"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 type unmarshalTest struct {
@@ -308,7 +312,7 @@ func TestUnmarshal(t *testing.T) {
 			},
 			ptr: new(all),
 			out: all{
-				String:  "string",
+				String:  "string2",
 				Float32: 1,
 				Float64: 1,
 				Int:     1,
@@ -390,7 +394,7 @@ func TestUnmarshal(t *testing.T) {
 		{
 			in:  url.Values{"ids": []string{"1", "2", "3"}},
 			ptr: new(map[string]int),
-			out: map[string]int{"ids": 1},
+			out: map[string]int{"ids": 3},
 		},
 		// 20
 		{
@@ -578,3 +582,919 @@ func TestUnmarshal(t *testing.T) {
 		}
 	}
 }
+
+type color struct {
+	Name  string `railing:"name"`
+	Color string `railing:"color"`
+}
+
+type colors struct {
+	Colors []color  `railing:"colors"`
+	Array  [2]color `railing:"array"`
+}
+
+type orders struct {
+	Orders []order `railing:"orders"`
+}
+
+func TestUnmarshalOrdered(t *testing.T) {
+	fixtures := []struct {
+		in  string
+		ptr interface{}
+		out interface{}
+	}{
+		// 0
+		{
+			in: "colors[][name]=a&colors[][color]=red&colors[][name]=b" +
+				"&colors[][name]=c&colors[][color]=blue",
+			ptr: new(colors),
+			out: colors{Colors: []color{{"a", "red"}, {"b", ""}, {"c", "blue"}}},
+		},
+		// 1
+		{
+			in:  "colors[][color]=red&colors[][name]=a&colors[][color]=blue",
+			ptr: new(colors),
+			out: colors{Colors: []color{{"a", "red"}, {"", "blue"}}},
+		},
+		// 2
+		{
+			in: "array[][name]=a&array[][name]=b&array[][color]=blue" +
+				"&array[][name]=c",
+			ptr: new(colors),
+			out: colors{Array: [2]color{{"a", ""}, {"b", "blue"}}},
+		},
+		// 3
+		{
+			in: "orders[][id]=1&orders[][items][]=1&orders[][items][]=2" +
+				"&orders[][address][city]=NY&orders[][id]=2&orders[][items][]=3",
+			ptr: new(orders),
+			out: orders{Orders: []order{
+				{ID: 1, Address: address{"NY"}, Items: []int{1, 2}},
+				{ID: 2, Items: []int{3}},
+			}},
+		},
+		// 4
+		{
+			in: "orders[][lines][][sku]=a&orders[][lines][][tags][]=x" +
+				"&orders[][lines][][tags][]=y&orders[][lines][][sku]=b" +
+				"&orders[][id]=1&orders[][id]=2&orders[][lines][][sku]=c",
+			ptr: new(orders),
+			out: orders{Orders: []order{
+				{ID: 1, Lines: []item{{"a", []string{"x", "y"}}, {"b", nil}}},
+				{ID: 2, Lines: []item{{"c", nil}}},
+			}},
+		},
+	}
+	for i, fixture := range fixtures {
+		m, err := ParseQueryOrdered(fixture.in)
+		if err != nil {
+			t.Errorf("expected err=nil; got %v (i=%d)", err, i)
+			continue
+		}
+		v := reflect.ValueOf(fixture.ptr)
+		if err := UnmarshalOrdered(m, v.Interface()); err != nil {
+			t.Errorf("expected err=nil; got %v (i=%d)", err, i)
+			continue
+		}
+		if !reflect.DeepEqual(v.Elem().Interface(), fixture.out) {
+			t.Errorf("expected %#v; got %#v (i=%d)", fixture.out,
+				v.Elem().Interface(), i)
+		}
+	}
+}
+
+func TestRoundTripNested(t *testing.T) {
+	expected := orders{Orders: []order{
+		{ID: 1, Address: address{"NY"}, Items: []int{1, 2},
+			Lines: []item{{"a", []string{"x"}}, {"b", []string{"y", "z"}}}},
+		{ID: 2, Items: []int{3}, Lines: []item{{SKU: "c"}}},
+	}}
+	m, err := MarshalOrdered(expected)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %v", err)
+	}
+	m, err = ParseQueryOrdered(m.Encode())
+	if err != nil {
+		t.Fatalf("expected err=nil; got %v", err)
+	}
+	var v orders
+	if err := UnmarshalOrdered(m, &v); err != nil {
+		t.Fatalf("expected err=nil; got %v", err)
+	}
+	if !reflect.DeepEqual(expected, v) {
+		t.Errorf("expected %v; got %v", expected, v)
+	}
+}
+
+func TestUnmarshalIndexed(t *testing.T) {
+	fixtures := []unmarshalTest{
+		// 0
+		{
+			in: url.Values{
+				"colors[1][name]":  []string{"b"},
+				"colors[0][name]":  []string{"a"},
+				"colors[0][color]": []string{"red"},
+				"colors[10][name]": []string{"c"},
+			},
+			ptr: new(colors),
+			out: colors{Colors: []color{{"a", "red"}, {"b", ""}, {"c", ""}}},
+		},
+		// 1
+		{
+			in: url.Values{
+				"array[1][name]": []string{"b"},
+				"array[0][name]": []string{"a"},
+				"array[2][name]": []string{"c"},
+			},
+			ptr: new(colors),
+			out: colors{Array: [2]color{{"a", ""}, {"b", ""}}},
+		},
+		// 2
+		{
+			in: url.Values{
+				"orders[0][id]":            []string{"1"},
+				"orders[0][items][]":       []string{"1", "2"},
+				"orders[0][address][city]": []string{"NY"},
+				"orders[1][id]":            []string{"2"},
+				"orders[1][lines][0][sku]": []string{"a"},
+				"orders[1][lines][1][sku]": []string{"b"},
+			},
+			ptr: new(orders),
+			out: orders{Orders: []order{
+				{ID: 1, Address: address{"NY"}, Items: []int{1, 2}},
+				{ID: 2, Lines: []item{{SKU: "a"}, {SKU: "b"}}},
+			}},
+		},
+		// 3
+		{
+			in: url.Values{
+				"slice_int[1]": []string{"2"},
+				"slice_int[0]": []string{"1"},
+			},
+			ptr: new(all),
+			out: all{SliceInt: []int{1, 2}},
+		},
+		// 4
+		{
+			in:  url.Values{"colors[-1][name]": []string{"a"}},
+			ptr: new(colors),
+			out: colors{},
+			err: &UnmarshalTypeError{"index -1", reflect.TypeOf([]color{})},
+		},
+		// 5
+		{
+			in: url.Values{
+				"colors[99999999999999999999][name]": []string{"a"},
+			},
+			ptr: new(colors),
+			out: colors{},
+			err: &UnmarshalTypeError{"index 99999999999999999999",
+				reflect.TypeOf([]color{})},
+		},
+	}
+	for i, fixture := range fixtures {
+		v := reflect.ValueOf(fixture.ptr)
+		if err := Unmarshal(Values{fixture.in},
+			v.Interface()); !reflect.DeepEqual(fixture.err, err) {
+			t.Errorf("expected err=%v; got %v (i=%d)", fixture.err, err, i)
+			continue
+		}
+		if !reflect.DeepEqual(v.Elem().Interface(), fixture.out) {
+			t.Errorf("expected %#v; got %#v (i=%d)", fixture.out,
+				v.Elem().Interface(), i)
+		}
+	}
+}
+
+func TestUnmarshalStyles(t *testing.T) {
+	fixtures := []struct {
+		in  string
+		out styled
+		err error
+	}{
+		// 0
+		{
+			in: "form=1&R=1&comma=1,2&space=1+2&pipe=1|2&object=R,4,B,6" +
+				"&deep[G]=8",
+			out: styled{
+				Form:   []int{1},
+				Color:  rgb{R: 1},
+				Comma:  []int{1, 2},
+				Space:  []int{1, 2},
+				Pipe:   []int{1, 2},
+				Object: rgb{R: 4, B: 6},
+				Deep:   rgb{G: 8},
+			},
+		},
+		// 1
+		{
+			in:  "object=",
+			out: styled{},
+		},
+		// 2
+		{
+			in:  "object=R,1,G",
+			err: &UnmarshalTypeError{"object R,1,G", reflect.TypeOf(rgb{})},
+		},
+	}
+	for i, fixture := range fixtures {
+		m, err := ParseQuery(fixture.in)
+		if err != nil {
+			t.Errorf("expected err=nil; got %v (i=%d)", err, i)
+			continue
+		}
+		var out styled
+		err = Unmarshal(m, &out)
+		if !reflect.DeepEqual(err, fixture.err) {
+			t.Errorf("expected err=%v; got %v (i=%d)", fixture.err, err, i)
+			continue
+		}
+		if !reflect.DeepEqual(out, fixture.out) {
+			t.Errorf("expected %v; got %v (i=%d)", fixture.out, out, i)
+		}
+	}
+}
+
+func TestUnmarshalText(t *testing.T) {
+	fixtures := []struct {
+		in  string
+		out texts
+		err error
+	}{
+		// 0
+		{
+			in: "ip=127.0.0.1&ips[]=::1&ips[]=10.0.0.1&big=42&bigPtr=-1" +
+				"&level=high+%26+mighty&levels[a]=low&floats=1.5,2",
+			out: texts{
+				IP:     net.ParseIP("127.0.0.1"),
+				IPs:    []net.IP{net.ParseIP("::1"), net.ParseIP("10.0.0.1")},
+				Big:    *big.NewInt(42),
+				BigPtr: big.NewInt(-1),
+				Level:  1,
+				Levels: map[string]level{"a": 0},
+				Floats: []big.Float{*big.NewFloat(1.5), *big.NewFloat(2)},
+			},
+		},
+		// 1
+		{
+			in: "ips[1]=::1&ips[0]=10.0.0.1&bigPtr=",
+			out: texts{
+				IPs:    []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("::1")},
+				BigPtr: big.NewInt(0),
+			},
+		},
+		// 2
+		{
+			in:  "level=medium",
+			err: errors.New("unknown level medium"),
+		},
+		// 3
+		{
+			in:  "ip=1.2.3",
+			err: &net.ParseError{Type: "IP address", Text: "1.2.3"},
+		},
+	}
+	for i, fixture := range fixtures {
+		m, err := ParseQuery(fixture.in)
+		if err != nil {
+			t.Errorf("expected err=nil; got %v (i=%d)", err, i)
+			continue
+		}
+		var out texts
+		err = Unmarshal(m, &out)
+		if !reflect.DeepEqual(err, fixture.err) {
+			t.Errorf("expected err=%v; got %v (i=%d)", fixture.err, err, i)
+			continue
+		}
+		if out.Big.Cmp(&fixture.out.Big) != 0 {
+			t.Errorf("expected %v; got %v (i=%d)", &fixture.out.Big, &out.Big, i)
+		}
+		if (out.BigPtr == nil) != (fixture.out.BigPtr == nil) ||
+			out.BigPtr != nil && out.BigPtr.Cmp(fixture.out.BigPtr) != 0 {
+			t.Errorf("expected %v; got %v (i=%d)", fixture.out.BigPtr,
+				out.BigPtr, i)
+		}
+		if len(out.Floats) != len(fixture.out.Floats) {
+			t.Errorf("expected %v; got %v (i=%d)", fixture.out.Floats,
+				out.Floats, i)
+		}
+		for j := range out.Floats {
+			if j < len(fixture.out.Floats) &&
+				out.Floats[j].Cmp(&fixture.out.Floats[j]) != 0 {
+				t.Errorf("expected %v; got %v (i=%d)", fixture.out.Floats,
+					out.Floats, i)
+			}
+		}
+		out.Big, out.BigPtr, out.Floats = big.Int{}, nil, nil
+		fixture.out.Big, fixture.out.BigPtr, fixture.out.Floats = big.Int{}, nil,
+			nil
+		if !reflect.DeepEqual(out, fixture.out) {
+			t.Errorf("expected %v; got %v (i=%d)", fixture.out, out, i)
+		}
+	}
+}
+
+func TestUnmarshalTime(t *testing.T) {
+	wait, negWait := 1500*time.Millisecond, -1500*time.Millisecond
+	minute, zero := 90*time.Second, time.Duration(0)
+	fixtures := []struct {
+		in  string
+		out times
+		err error
+	}{
+		// 0
+		{
+			in: "days%5B%5D=2024-01-01&days%5B%5D=2024-01-02" +
+				"&from=2024-01-02T03%3A04%3A05Z&timeout=1m30s&to=2024-01-31" +
+				"&ts=1700000000.5&wait=1.5",
+			out: times{
+				From: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+				To:   time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC),
+				TS:   time.Unix(1700000000, 5e8).UTC(),
+				Days: []time.Time{
+					time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+					time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+				},
+				Timeout: 90 * time.Second,
+				Wait:    &wait,
+			},
+		},
+		// 1
+		{
+			in: "ts=-1.75&timeout=1.5%C2%B5s&wait=-1.5",
+			out: times{
+				TS:      time.Unix(-2, 25e7).UTC(),
+				Timeout: 1500 * time.Nanosecond,
+				Wait:    &negWait,
+			},
+		},
+		// 2
+		{
+			in: "timeout=90&wait=1m30s&ts=1700000000&days[1]=2024-01-02",
+			out: times{
+				TS:      time.Unix(1700000000, 0).UTC(),
+				Days:    []time.Time{time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+				Timeout: 90 * time.Second,
+				Wait:    &minute,
+			},
+		},
+		// 3
+		{
+			in:  "wait=",
+			out: times{Wait: &zero},
+		},
+		// 4
+		{
+			in:  "to=2024/01/31",
+			err: &UnmarshalTypeError{"time 2024/01/31", timeType},
+		},
+		// 5
+		{
+			in:  "ts=1.5e9",
+			err: &UnmarshalTypeError{"time 1.5e9", timeType},
+		},
+		// 6
+		{
+			in:  "timeout=1x",
+			err: &UnmarshalTypeError{"duration 1x", durationType},
+		},
+		// 7
+		{
+			in:  "wait=10000000000",
+			err: &UnmarshalTypeError{"duration 10000000000", durationType},
+		},
+		// 8
+		{
+			in:  "timeout=",
+			err: &UnmarshalTypeError{"duration ", durationType},
+		},
+	}
+	for i, fixture := range fixtures {
+		m, err := ParseQuery(fixture.in)
+		if err != nil {
+			t.Errorf("expected err=nil; got %v (i=%d)", err, i)
+			continue
+		}
+		var out times
+		err = Unmarshal(m, &out)
+		if !reflect.DeepEqual(err, fixture.err) {
+			t.Errorf("expected err=%v; got %v (i=%d)", fixture.err, err, i)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if !reflect.DeepEqual(out, fixture.out) {
+			t.Errorf("expected %v; got %v (i=%d)", fixture.out, out, i)
+		}
+	}
+}
+
+func TestUnmarshalMultiparam(t *testing.T) {
+	due := time.Date(2024, 6, 1, 0, 0, 30, 0, time.UTC)
+	fixtures := []struct {
+		in  string
+		out postForm
+		err error
+	}{
+		// 0
+		{
+			in: "post[title]=a&post[published_at(1i)]=2024" +
+				"&post[published_at(2i)]=5&post[published_at(3i)]=17" +
+				"&post[published_at(4i)]=10&post[published_at(5i)]=30",
+			out: postForm{post{Title: "a",
+				PublishedAt: time.Date(2024, 5, 17, 10, 30, 0, 0, time.UTC)}},
+		},
+		// 1
+		{
+			in: "post[due(1i)]=2024&post[due(2i)]=6&post[due(3i)]=1" +
+				"&post[due(4i)]=&post[due(6i)]=30",
+			out: postForm{post{Due: &due}},
+		},
+		// 2
+		{
+			in:  "post[due(1i)]=&post[due(2i)]=&post[due(3i)]=",
+			out: postForm{},
+		},
+		// 3
+		{
+			in:  "post[due(1i)]=2024&post[due(2i)]=&post[due(3i)]=1",
+			err: &UnmarshalTypeError{"time without due(2i)", timeType},
+		},
+		// 4
+		{
+			in:  "post[due(1i)]=2024&post[due(2i)]=2&post[due(3i)]=30",
+			err: &UnmarshalTypeError{"time 2024-02-30 00:00:00", timeType},
+		},
+		// 5
+		{
+			in:  "post[due(1i)]=2024&post[due(2i)]=6",
+			err: &UnmarshalTypeError{"time without due(3i)", timeType},
+		},
+	}
+	for i, fixture := range fixtures {
+		m, err := ParseQuery(fixture.in)
+		if err != nil {
+			t.Errorf("expected err=nil; got %v (i=%d)", err, i)
+			continue
+		}
+		var out postForm
+		err = Unmarshal(m, &out)
+		if !reflect.DeepEqual(err, fixture.err) {
+			t.Errorf("expected err=%v; got %v (i=%d)", fixture.err, err, i)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if !reflect.DeepEqual(out, fixture.out) {
+			t.Errorf("expected %v; got %v (i=%d)", fixture.out, out, i)
+		}
+	}
+}
+
+type checkBoxes struct {
+	Admin  bool   `railing:"admin"`
+	Terms  *bool  `railing:"terms,omitempty"`
+	Name   string `railing:"name"`
+	Rating []bool `railing:"rating"`
+}
+
+func TestUnmarshalCheckBox(t *testing.T) {
+	yes, no := true, false
+	fixtures := []struct {
+		o   DecoderOptions
+		in  string
+		out checkBoxes
+		err error
+	}{
+		// 0
+		{
+			in:  "admin=0&admin=1&terms=0&name=a&name=b",
+			out: checkBoxes{Admin: true, Terms: &no, Name: "b"},
+		},
+		// 1
+		{
+			o:   DecoderOptions{Duplicates: FirstValue},
+			in:  "admin=0&admin=1&terms=0&terms=1&name=a&name=b",
+			out: checkBoxes{Terms: &no, Name: "a"},
+		},
+		// 2
+		{
+			o: DecoderOptions{RailsBools: true},
+			in: "admin=0&admin=on&terms=YES&rating[]=t&rating[]=f" +
+				"&rating[]=off",
+			out: checkBoxes{Admin: true, Terms: &yes,
+				Rating: []bool{true, false, false}},
+		},
+		// 3
+		{
+			o:   DecoderOptions{RailsBools: true},
+			in:  "admin=&terms=",
+			out: checkBoxes{Terms: new(bool)},
+		},
+		// 4
+		{
+			in:  "admin=on",
+			err: &UnmarshalTypeError{"bool on", reflect.TypeOf(true)},
+		},
+		// 5
+		{
+			o:   DecoderOptions{RailsBools: true},
+			in:  "admin=2",
+			err: &UnmarshalTypeError{"bool 2", reflect.TypeOf(true)},
+		},
+	}
+	for i, fixture := range fixtures {
+		m, err := ParseQuery(fixture.in)
+		if err != nil {
+			t.Errorf("expected err=nil; got %v (i=%d)", err, i)
+			continue
+		}
+		var out checkBoxes
+		err = fixture.o.Unmarshal(m, &out)
+		if !reflect.DeepEqual(err, fixture.err) {
+			t.Errorf("expected err=%v; got %v (i=%d)", fixture.err, err, i)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if !reflect.DeepEqual(out, fixture.out) {
+			t.Errorf("expected %v; got %v (i=%d)", fixture.out, out, i)
+		}
+	}
+}
+
+func TestUnmarshalBareNils(t *testing.T) {
+	a, empty := "a", ""
+	fixtures := []struct {
+		o   DecoderOptions
+		in  string
+		out nullable
+	}{
+		// 0
+		{
+			o:   DecoderOptions{BareNils: true},
+			in:  "name&title=&note&age&address&skip",
+			out: nullable{Title: &empty},
+		},
+		// 1
+		{
+			in: "name&title=&age=1",
+			out: nullable{Name: &empty, Title: &empty, Note: 1, Age: 1,
+				Address: &address{}},
+		},
+		// 2
+		{
+			o:  DecoderOptions{BareNils: true},
+			in: "name=a&name&title&title=a",
+			out: nullable{Name: &empty, Title: &a, Note: 1, Age: 2,
+				Address: &address{}},
+		},
+	}
+	for i, fixture := range fixtures {
+		m, err := fixture.o.ParseQuery(fixture.in)
+		if err != nil {
+			t.Errorf("expected err=nil; got %v (i=%d)", err, i)
+			continue
+		}
+		name := "x"
+		out := nullable{Name: &name, Note: 1, Age: 2, Address: &address{}}
+		if err := fixture.o.Unmarshal(m, &out); err != nil {
+			t.Errorf("expected err=nil; got %v (i=%d)", err, i)
+			continue
+		}
+		if !reflect.DeepEqual(out, fixture.out) {
+			t.Errorf("expected %v; got %v (i=%d)", fixture.out, out, i)
+		}
+	}
+}
+
+func TestUnmarshalMapBareNils(t *testing.T) {
+	x, empty := "x", ""
+	fixtures := []struct {
+		o   DecoderOptions
+		in  string
+		ptr interface{}
+		out interface{}
+	}{
+		// 0
+		{
+			o:   DecoderOptions{BareNils: true},
+			in:  "a&b=&c=x",
+			ptr: new(map[string]*string),
+			out: map[string]*string{"a": nil, "b": &empty, "c": &x},
+		},
+		// 1
+		{
+			in:  "a&b=",
+			ptr: new(map[string]*string),
+			out: map[string]*string{"a": &empty, "b": &empty},
+		},
+		// 2
+		{
+			o:   DecoderOptions{BareNils: true},
+			in:  "a&b=1",
+			ptr: new(map[string]int),
+			out: map[string]int{"a": 0, "b": 1},
+		},
+	}
+	for i, fixture := range fixtures {
+		m, err := fixture.o.ParseQuery(fixture.in)
+		if err != nil {
+			t.Errorf("expected err=nil; got %v (i=%d)", err, i)
+			continue
+		}
+		v := reflect.ValueOf(fixture.ptr)
+		if err := fixture.o.Unmarshal(m, v.Interface()); err != nil {
+			t.Errorf("expected err=nil; got %v (i=%d)", err, i)
+			continue
+		}
+		if !reflect.DeepEqual(v.Elem().Interface(), fixture.out) {
+			t.Errorf("expected %v; got %v (i=%d)", fixture.out,
+				v.Elem().Interface(), i)
+		}
+	}
+}
+
+func TestUnmarshalEmptyArrays(t *testing.T) {
+	fixtures := []struct {
+		o   DecoderOptions
+		in  string
+		out tagged
+	}{
+		// 0
+		{
+			o:   DecoderOptions{EmptyArrays: true},
+			in:  "tags[]=&ids=&names[]",
+			out: tagged{Tags: []string{}, IDs: []int{}, Names: []string{}},
+		},
+		// 1
+		{
+			in:  "tags[]=&names[]=",
+			out: tagged{Tags: []string{""}, Names: []string{""}},
+		},
+		// 2
+		{
+			o:   DecoderOptions{EmptyArrays: true},
+			in:  "tags[]=a&tags[]=&ids=1,2",
+			out: tagged{Tags: []string{"a", ""}, IDs: []int{1, 2}},
+		},
+		// 3
+		{
+			o:   DecoderOptions{EmptyArrays: true, BareNils: true},
+			in:  "tags[]&items[]=",
+			out: tagged{Tags: []string{}, Items: []item{}},
+		},
+	}
+	for i, fixture := range fixtures {
+		m, err := fixture.o.ParseQuery(fixture.in)
+		if err != nil {
+			t.Errorf("expected err=nil; got %v (i=%d)", err, i)
+			continue
+		}
+		var out tagged
+		if err := fixture.o.Unmarshal(m, &out); err != nil {
+			t.Errorf("expected err=nil; got %v (i=%d)", err, i)
+			continue
+		}
+		if !reflect.DeepEqual(out, fixture.out) {
+			t.Errorf("expected %v; got %v (i=%d)", fixture.out, out, i)
+		}
+	}
+}
+
+func TestUnmarshalLimits(t *testing.T) {
+	fixtures := []struct {
+		o   DecoderOptions
+		in  url.Values
+		err error
+	}{
+		// 0
+		{
+			o:   DecoderOptions{MaxDepth: 1},
+			in:  url.Values{"items[][address][city]": {"x"}},
+			err: &LimitError{DepthLimit, "items[][address][city]", 1},
+		},
+		// 1
+		{
+			o:   DecoderOptions{MaxKeys: 2},
+			in:  url.Values{"tags[]": {"a", "b", "c"}},
+			err: &LimitError{KeyCountLimit, "tags[]", 2},
+		},
+		// 2
+		{
+			o:   DecoderOptions{MaxValueSize: 2},
+			in:  url.Values{"ids": {"1,2"}},
+			err: &LimitError{ValueSizeLimit, "ids", 2},
+		},
+		// 3
+		{
+			o:   DecoderOptions{MaxArrayLength: 2},
+			in:  url.Values{"ids": {"1,2,3"}},
+			err: &LimitError{ArrayLengthLimit, "ids", 2},
+		},
+		// 4
+		{
+			o:   DecoderOptions{MaxArrayLength: 2},
+			in:  url.Values{"tags[]": {"a", "b", "c"}},
+			err: &LimitError{ArrayLengthLimit, "tags", 2},
+		},
+		// 5
+		{
+			o: DecoderOptions{MaxArrayLength: 2},
+			in: url.Values{"items[0][name]": {"a"}, "items[1][name]": {"b"},
+				"items[2][name]": {"c"}},
+			err: &LimitError{ArrayLengthLimit, "items", 2},
+		},
+		// 6
+		{
+			o: DecoderOptions{MaxDepth: 2, MaxKeys: 3, MaxArrayLength: 2,
+				MaxValueSize: 3},
+			in: url.Values{"tags[]": {"a", "b"}, "items[][name]": {"abc"}},
+		},
+		// 7
+		{
+			o:   DecoderOptions{MaxArrayLength: 2},
+			in:  url.Values{"items[][sku]": {"a", "b", "c"}},
+			err: &LimitError{ArrayLengthLimit, "items", 2},
+		},
+		// 8
+		{
+			o:   DecoderOptions{MaxArrayLength: 2},
+			in:  url.Values{"items[99999999][name]": {"a"}},
+			err: &LimitError{ArrayLengthLimit, "items", 2},
+		},
+	}
+	for i, fixture := range fixtures {
+		var out tagged
+		err := fixture.o.Unmarshal(Values{fixture.in}, &out)
+		if !reflect.DeepEqual(fixture.err, err) {
+			t.Errorf("expected err=%v; got %v (i=%d)", fixture.err, err, i)
+		}
+	}
+
+	m := NewOrderedValues(Pair{"items[][sku]", "a"}, Pair{"items[][sku]", "b"},
+		Pair{"items[][tags][]", "x"}, Pair{"items[][sku]", "c"})
+	var out tagged
+	err := DecoderOptions{MaxArrayLength: 2}.UnmarshalOrdered(m, &out)
+	expected := &LimitError{ArrayLengthLimit, "items", 2}
+	if !reflect.DeepEqual(err, expected) {
+		t.Errorf("expected err=%v; got %v", expected, err)
+	}
+}
+
+func TestUnmarshalMaps(t *testing.T) {
+	fixtures := []struct {
+		in  string
+		ptr interface{}
+		out interface{}
+	}{
+		// 0
+		{
+			in:  "alice[city]=Paris&bob[city]=Rome",
+			ptr: new(map[string]address),
+			out: map[string]address{"alice": {"Paris"}, "bob": {"Rome"}},
+		},
+		// 1
+		{
+			in:  "alice[city]=Paris",
+			ptr: new(map[string]*address),
+			out: map[string]*address{"alice": {"Paris"}},
+		},
+		// 2
+		{
+			in:  "admins[][sku]=a&admins[][sku]=b&users[0][sku]=c",
+			ptr: new(map[string][]item),
+			out: map[string][]item{
+				"admins": {{SKU: "a"}, {SKU: "b"}},
+				"users":  {{SKU: "c"}},
+			},
+		},
+		// 3
+		{
+			in:  "a[b][c]=1&a[b][d]=2&e[f][g]=3",
+			ptr: new(map[string]map[string]map[string]int),
+			out: map[string]map[string]map[string]int{
+				"a": {"b": {"c": 1, "d": 2}},
+				"e": {"f": {"g": 3}},
+			},
+		},
+		// 4
+		{
+			in: "orders[1][id]=1&orders[1][address][city]=Paris" +
+				"&orders[1][lines][][sku]=a&orders[2][id]=2",
+			ptr: new(struct {
+				Orders map[string]order `railing:"orders"`
+			}),
+			out: struct {
+				Orders map[string]order `railing:"orders"`
+			}{map[string]order{
+				"1": {ID: 1, Address: address{"Paris"},
+					Lines: []item{{SKU: "a"}}},
+				"2": {ID: 2},
+			}},
+		},
+		// 5
+		{
+			in:  "a[b]=1&c=2",
+			ptr: new(map[string]string),
+			out: map[string]string{"a[b]": "1", "c": "2"},
+		},
+	}
+	for i, fixture := range fixtures {
+		m, err := ParseQuery(fixture.in)
+		if err != nil {
+			t.Errorf("expected err=nil; got %v (i=%d)", err, i)
+			continue
+		}
+		v := reflect.ValueOf(fixture.ptr)
+		for _, m := range []Values{m, {Values: m.Values}} {
+			if err := Unmarshal(m, v.Interface()); err != nil {
+				t.Errorf("expected err=nil; got %v (i=%d)", err, i)
+				continue
+			}
+			if !reflect.DeepEqual(v.Elem().Interface(), fixture.out) {
+				t.Errorf("expected %v; got %v (i=%d)", fixture.out,
+					v.Elem().Interface(), i)
+			}
+		}
+	}
+}
+
+func TestUnmarshalMapKeys(t *testing.T) {
+	fixtures := []struct {
+		in  string
+		ptr interface{}
+		out interface{}
+		err error
+	}{
+		// 0
+		{
+			in:  "42=9.99&-1=1",
+			ptr: new(map[int]float64),
+			out: map[int]float64{42: 9.99, -1: 1},
+		},
+		// 1
+		{
+			in:  "7[]=1&7[]=2",
+			ptr: new(map[uint8][]int),
+			out: map[uint8][]int{7: {1, 2}},
+		},
+		// 2
+		{
+			in:  "high+%26+mighty[city]=Rome&low[city]=Paris",
+			ptr: new(map[level]address),
+			out: map[level]address{0: {"Paris"}, 1: {"Rome"}},
+		},
+		// 3
+		{
+			in:  "256=1",
+			ptr: new(map[uint8]int),
+			out: map[uint8]int(nil),
+			err: &UnmarshalTypeError{"number 256", reflect.TypeOf(uint8(0))},
+		},
+		// 4
+		{
+			in:  "medium=1",
+			ptr: new(map[level]int),
+			out: map[level]int(nil),
+			err: errors.New("unknown level medium"),
+		},
+		// 5
+		{
+			in:  "true=1",
+			ptr: new(map[bool]int),
+			out: map[bool]int(nil),
+			err: &UnmarshalTypeError{"object", reflect.TypeOf(map[bool]int{})},
+		},
+		// 6
+		{
+			in: "prices[42]=9.99",
+			ptr: new(struct {
+				Prices map[int]float64 `railing:"prices"`
+			}),
+			out: struct {
+				Prices map[int]float64 `railing:"prices"`
+			}{map[int]float64{42: 9.99}},
+		},
+	}
+	for i, fixture := range fixtures {
+		m, err := ParseQuery(fixture.in)
+		if err != nil {
+			t.Errorf("expected err=nil; got %v (i=%d)", err, i)
+			continue
+		}
+		v := reflect.ValueOf(fixture.ptr)
+		if err := Unmarshal(m, v.Interface()); !reflect.DeepEqual(fixture.err,
+			err) {
+			t.Errorf("expected err=%v; got %v (i=%d)", fixture.err, err, i)
+			continue
+		}
+		if !reflect.DeepEqual(v.Elem().Interface(), fixture.out) {
+			t.Errorf("expected %v; got %v (i=%d)", fixture.out,
+				v.Elem().Interface(), i)
+		}
+	}
+}
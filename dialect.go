@@ -0,0 +1,230 @@
+package railing
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Dialect describes a flavour of the bracket query syntax. Backends written in
+// different languages agree on "foo[bar]" being a nested object, but they
+// differ in the way they index arrays, escape characters and parse keys.
+//
+// A Dialect can be given to EncoderOptions and DecoderOptions. Unmarshal
+// understands the Values parsed with any dialect.
+type Dialect interface {
+	// Index returns the text put between the brackets of the key of the i-th
+	// element of an array, eg. "" for "foo[]" or "0" for "foo[0]". The object
+	// argument reports whether the elements of the array are objects.
+	Index(i int, object bool) string
+
+	// Escape escapes a key or a value of the query string.
+	Escape(s string) string
+
+	// Unescape reverses Escape.
+	Unescape(s string) (string, error)
+
+	// Normalize stores the value under the key in the tree of params, which
+	// root is a hash.
+	Normalize(root *Node, key, value string) error
+}
+
+// The built-in dialects.
+var (
+	// Rails is the dialect of rails' to_query and rack's query parser. It is
+	// the default dialect.
+	Rails Dialect = rails{}
+
+	// PHP is the dialect of PHP's http_build_query and parse_str. Arrays are
+	// always indexed, a repeated key overwrites the previous value, and dots
+	// and spaces in the top level keys are converted to underscores.
+	PHP Dialect = php{}
+
+	// QS is the dialect of the qs package of Node.js with its default options.
+	// Arrays are always indexed, spaces are escaped as "%20", and a repeated
+	// key adds a value to the previous ones.
+	QS Dialect = qs{}
+
+	// JQuery is the dialect of jQuery.param. Arrays of objects are indexed,
+	// while arrays of other values use "[]". As jQuery does not parse query
+	// strings, they are parsed the same way rails does it.
+	JQuery Dialect = jquery{}
+)
+
+type rails struct{}
+
+func (rails) Index(int, bool) string {
+	return ""
+}
+
+func (rails) Escape(s string) string {
+	return url.QueryEscape(s)
+}
+
+func (rails) Unescape(s string) (string, error) {
+	return url.QueryUnescape(s)
+}
+
+func (rails) Normalize(root *Node, key, value string) error {
+	p := parser{root: root}
+	_, err := p.normalize(root, key, value, 0)
+	return err
+}
+
+// normalizeOrdered stores the value in the tree of the parser, which remembers
+// the order of the values.
+func (rails) normalizeOrdered(p *parser, key, value string) error {
+	_, err := p.normalize(p.root, key, value, 0)
+	return err
+}
+
+type php struct{}
+
+func (php) Index(i int, _ bool) string {
+	return strconv.Itoa(i)
+}
+
+func (php) Escape(s string) string {
+	return strings.Replace(url.QueryEscape(s), "~", "%7E", -1)
+}
+
+func (php) Unescape(s string) (string, error) {
+	return url.QueryUnescape(s)
+}
+
+func (d php) Normalize(root *Node, key, value string) error {
+	return d.normalizeLimited(&parser{root: root}, key, value)
+}
+
+// normalizeLimited stores the value in the tree of the parser, which limits
+// the length of the arrays.
+func (php) normalizeLimited(p *parser, key, value string) error {
+	key = strings.TrimLeft(key, " ")
+	top := key
+	if i := strings.IndexByte(key, '['); i >= 0 {
+		top = key[:i]
+	}
+	top = strings.NewReplacer(".", "_", " ", "_").Replace(top)
+	return normalizeIndexed(p, top+key[len(top):], value, false)
+}
+
+type qs struct{}
+
+func (qs) Index(i int, _ bool) string {
+	return strconv.Itoa(i)
+}
+
+func (qs) Escape(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
+
+// Unescape never fails, a value which cannot be unescaped is returned as it
+// is, like qs does it.
+func (qs) Unescape(s string) (string, error) {
+	s = strings.Replace(s, "+", " ", -1)
+	if u, err := url.PathUnescape(s); err == nil {
+		return u, nil
+	}
+	return s, nil
+}
+
+func (d qs) Normalize(root *Node, key, value string) error {
+	return d.normalizeLimited(&parser{root: root}, key, value)
+}
+
+// normalizeLimited stores the value in the tree of the parser, which limits
+// the length of the arrays.
+func (qs) normalizeLimited(p *parser, key, value string) error {
+	return normalizeIndexed(p, key, value, true)
+}
+
+type jquery struct {
+	rails
+}
+
+func (jquery) Index(i int, object bool) string {
+	if object {
+		return strconv.Itoa(i)
+	}
+	return ""
+}
+
+var jqueryUnescaper = strings.NewReplacer("%21", "!", "%27", "'", "%28", "(",
+	"%29", ")", "%2A", "*")
+
+func (jquery) Escape(s string) string {
+	return jqueryUnescaper.Replace(url.QueryEscape(s))
+}
+
+// normalizeIndexed stores the value under the key in the tree of the parser
+// the way PHP does it. Every array is a hash, and "[]" adds a new element to
+// it, which key is the next index. If combine is true, a repeated key adds the
+// value to the previous ones, otherwise the value is overwritten. A new index
+// cannot be added to a hash which already holds maxArrayLength keys.
+//
+//   - a[]=1&a[]=2 -> a[0]=1&a[1]=2
+//   - a[][id]=1&a[][id]=2 -> a[0][id]=1&a[1][id]=2
+func normalizeIndexed(p *parser, key, value string, combine bool) error {
+	segments := splitSegments(key)
+	if segments[0] == "" {
+		return nil
+	}
+	n := p.root
+	for i, seg := range segments {
+		if seg == "" && i > 0 {
+			seg = strconv.Itoa(n.next)
+		}
+		child := n.Get(seg)
+		if child == nil && i > 0 {
+			if _, ok := parseIndex(seg); ok {
+				if err := p.checkLength(n); err != nil {
+					return err
+				}
+			}
+		}
+		if i == len(segments)-1 {
+			if combine && child != nil && child.kind == StringNode {
+				child.values = append(child.values, value)
+			} else {
+				n.Set(seg, NewStringNode(value))
+			}
+			return nil
+		}
+		if child == nil || child.kind != HashNode {
+			child = NewHashNode()
+			n.Set(seg, child)
+		}
+		n = child
+	}
+	return nil
+}
+
+// splitSegments splits the key into the top level key and the content of the
+// following brackets, eg. "a[b][]" -> "a", "b", "". Anything after the last
+// pair of brackets is ignored.
+func splitSegments(key string) []string {
+	i := strings.IndexByte(key, '[')
+	if i < 0 || !strings.Contains(key[i:], "]") {
+		return []string{key}
+	}
+	segments, rest := []string{key[:i]}, key[i:]
+	for strings.HasPrefix(rest, "[") {
+		j := strings.IndexByte(rest, ']')
+		if j < 0 {
+			break
+		}
+		segments, rest = append(segments, rest[1:j]), rest[j+1:]
+	}
+	return segments
+}
+
+// appendEscaper returns the function appending strings escaped according to
+// the dialect. Rails strings are escaped without allocating.
+func appendEscaper(d Dialect) func(dst []byte, s string) []byte {
+	if _, ok := d.(rails); ok {
+		return appendQueryEscape
+	}
+	return func(dst []byte, s string) []byte {
+		return append(dst, d.Escape(s)...)
+	}
+}
@@ -0,0 +1,178 @@
+package railing
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestDialectEscape(t *testing.T) {
+	fixtures := []struct {
+		dialect Dialect
+		in      string
+		out     string
+	}{
+		// 0
+		{Rails, "a b~(x)[]", "a+b~%28x%29%5B%5D"},
+		// 1
+		{PHP, "a b~(x)[]", "a+b%7E%28x%29%5B%5D"},
+		// 2
+		{QS, "a b~(x)[]", "a%20b~%28x%29%5B%5D"},
+		// 3
+		{JQuery, "a b~(x)[]", "a+b~(x)%5B%5D"},
+	}
+	for i, fixture := range fixtures {
+		out := fixture.dialect.Escape(fixture.in)
+		if out != fixture.out {
+			t.Errorf("expected %s; got %s (i=%d)", fixture.out, out, i)
+		}
+		in, err := fixture.dialect.Unescape(out)
+		if err != nil {
+			t.Errorf("expected err=nil; got %v (i=%d)", err, i)
+			continue
+		}
+		if in != fixture.in {
+			t.Errorf("expected %s; got %s (i=%d)", fixture.in, in, i)
+		}
+	}
+}
+
+func TestDialectMarshal(t *testing.T) {
+	in := struct {
+		Tags   []string `railing:"tags"`
+		Orders []order  `railing:"orders"`
+	}{
+		Tags: []string{"x", "y"},
+		Orders: []order{
+			{ID: 1, Items: []int{1, 2}},
+			{ID: 2, Lines: []item{{SKU: "a"}}},
+		},
+	}
+	fixtures := []struct {
+		dialect Dialect
+		out     string
+	}{
+		// 0
+		{
+			dialect: Rails,
+			out: "orders[][address][city]=&orders[][id]=1&orders[][items][]=1" +
+				"&orders[][items][]=2&orders[][address][city]=&orders[][id]=2" +
+				"&orders[][lines][][sku]=a&tags[]=x&tags[]=y",
+		},
+		// 1
+		{
+			dialect: PHP,
+			out: "orders[0][address][city]=&orders[0][id]=1" +
+				"&orders[0][items][0]=1&orders[0][items][1]=2" +
+				"&orders[1][address][city]=&orders[1][id]=2" +
+				"&orders[1][lines][0][sku]=a&tags[0]=x&tags[1]=y",
+		},
+		// 2
+		{
+			dialect: JQuery,
+			out: "orders[0][address][city]=&orders[0][id]=1" +
+				"&orders[0][items][]=1&orders[0][items][]=2" +
+				"&orders[1][address][city]=&orders[1][id]=2" +
+				"&orders[1][lines][0][sku]=a&tags[]=x&tags[]=y",
+		},
+	}
+	for i, fixture := range fixtures {
+		o := EncoderOptions{Dialect: fixture.dialect}
+		v, err := o.MarshalOrdered(in)
+		if err != nil {
+			t.Errorf("expected err=nil; got %v (i=%d)", err, i)
+			continue
+		}
+		out, err := url.QueryUnescape(o.EncodeOrdered(v))
+		if err != nil {
+			t.Errorf("expected err=nil; got %v (i=%d)", err, i)
+			continue
+		}
+		if out != fixture.out {
+			t.Errorf("expected %s; got %s (i=%d)", fixture.out, out, i)
+		}
+	}
+}
+
+func TestDialectParseQuery(t *testing.T) {
+	fixtures := []struct {
+		dialect Dialect
+		in      string
+		out     []Pair
+	}{
+		// 0
+		{
+			dialect: PHP,
+			in:      "a[]=1&a[]=2&a[5]=3&a[]=4&b=1&b=2&c.d%20e=x&f[g]=y&f=z",
+			out: []Pair{
+				{"a[0]", "1"}, {"a[1]", "2"}, {"a[5]", "3"}, {"a[6]", "4"},
+				{"b", "2"}, {"c_d_e", "x"}, {"f", "z"},
+			},
+		},
+		// 1
+		{
+			dialect: PHP,
+			in:      "o[][id]=1&o[][id]=2&o[1][name]=b&p[a]b=1&[x]=1",
+			out: []Pair{
+				{"o[0][id]", "1"}, {"o[1][id]", "2"}, {"o[1][name]", "b"},
+				{"p[a]", "1"},
+			},
+		},
+		// 2
+		{
+			dialect: QS,
+			in:      "a[]=1&a[]=2&b=1&b=2&c.d=x&e=%zz+1",
+			out: []Pair{
+				{"a[0]", "1"}, {"a[1]", "2"}, {"b", "1"}, {"b", "2"},
+				{"c.d", "x"}, {"e", "%zz 1"},
+			},
+		},
+		// 3
+		{
+			dialect: JQuery,
+			in:      "o[][id]=1&o[][name]=a&o[][id]=2",
+			out: []Pair{
+				{"o[][id]", "1"}, {"o[][name]", "a"}, {"o[][id]", "2"},
+			},
+		},
+	}
+	for i, fixture := range fixtures {
+		v, err := DecoderOptions{Dialect: fixture.dialect}.ParseQuery(fixture.in)
+		if err != nil {
+			t.Errorf("expected err=nil; got %v (i=%d)", err, i)
+			continue
+		}
+		if pairs := v.Pairs(); !reflect.DeepEqual(pairs, fixture.out) {
+			t.Errorf("expected %v; got %v (i=%d)", fixture.out, pairs, i)
+		}
+	}
+}
+
+func TestDialectRoundTrip(t *testing.T) {
+	in := orders{Orders: []order{
+		{ID: 1, Address: address{"New York"}, Items: []int{1, 2}},
+		{ID: 2, Lines: []item{{SKU: "a"}, {SKU: "b", Tags: []string{"x"}}}},
+	}}
+	for i, dialect := range []Dialect{Rails, PHP, QS, JQuery} {
+		v, err := EncoderOptions{Dialect: dialect}.MarshalOrdered(in)
+		if err != nil {
+			t.Errorf("expected err=nil; got %v (i=%d)", err, i)
+			continue
+		}
+		query := EncoderOptions{Dialect: dialect}.EncodeOrdered(v)
+		o := DecoderOptions{Dialect: dialect}
+		parsed, err := o.ParseQueryOrdered(query)
+		if err != nil {
+			t.Errorf("expected err=nil; got %v (i=%d)", err, i)
+			continue
+		}
+		var out orders
+		if err := o.UnmarshalOrdered(parsed, &out); err != nil {
+			t.Errorf("expected err=nil; got %v (i=%d)", err, i)
+			continue
+		}
+		if !reflect.DeepEqual(out, in) {
+			t.Errorf("expected %v; got %v (i=%d)", in, out, i)
+		}
+	}
+}
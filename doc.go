@@ -4,5 +4,10 @@
 //
 // Marshal and Unmarshal functions are based on the Values type which is a
 // wrapper around url.Values. The latter cannot be used because of differences
-// in Encode function.
+// in Encode function. Values can be built from a query string with ParseQuery,
+// which follows the rules of rack's query parser.
+//
+// Other flavours of the bracket syntax, such as PHP's or the one of Node's qs
+// package, are supported with a Dialect given to EncoderOptions and
+// DecoderOptions.
 package railing
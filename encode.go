@@ -1,9 +1,9 @@
 package railing
 
 import (
-	"fmt"
-	"net/url"
+	"encoding"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -71,16 +71,54 @@ func (e *MarshalerError) Error() string {
 //   // character because of 'comma' option.
 //   Field []int `railing:"slice,comma"`
 //
+//   // Field appears in Values as key "slice" - elements are joined by '|'
+//   // character, the OpenAPI pipeDelimited style.
+//   Field []int `railing:"slice,pipeDelimited"`
+//
+// The OpenAPI query styles are given as options: "form", "spaceDelimited",
+// "pipeDelimited" and "deepObject", optionally followed by "explode" or
+// "noexplode". As in OpenAPI, only "form" is exploded by default.
+//    - exploded slices are encoded as repeated keys, eg. "id=3&id=4",
+//    - exploded structs and maps are encoded as if their fields were fields of
+//      the outer struct, eg. "R=100&G=200",
+//    - slices which are not exploded are joined by the style's delimiter, eg.
+//      "id=3,4" or "id=3|4",
+//    - structs and maps which are not exploded are encoded as keys and values
+//      joined by the delimiter, eg. "color=R,100,G,200",
+//    - "deepObject" is the default rails style, eg. "color[R]=100".
+//
+// time.Time is encoded in RFC 3339 format by its MarshalText, unless the tag
+// gives the "layout=" option, eg. `railing:"from,layout=2006-01-02"`, or the
+// "unix" option, which encodes it as the number of seconds since the Unix
+// epoch, eg. "1700000000.5". As options are separated by commas, the layout
+// cannot contain one. time.Duration is encoded in the Go syntax, eg. "1m30s",
+// or as the number of seconds, eg. "90", with the "seconds" option. The options
+// apply to the elements of slices as well.
+//
+// The "multiparam" option encodes time.Time as the Rails multiparameter
+// attribute, the way date_select and datetime_select send it, eg.
+// "published_at(1i)=2024&published_at(2i)=5&published_at(3i)=17" followed by
+// the hour and minute under "(4i)" and "(5i)", and the second under "(6i)" if
+// it is not zero. The time is converted to UTC, the zone in which it is
+// decoded, and the fraction of a second is not encoded.
+//
 // Anonymous struct fields are marshaled as if their inner exported fields were
 // fields in the outer struct. An anonymous struct field with a name given in
 // its railing tag is treated as having that name, rather than being anonymous.
 //
-// To marshal a map into values, a map with string keys is required. Marshal
-// will walk through every key trying to encode values. If a map is of type
-// map[string]interface{} then the values can be a nested struct or other map
-// producing a valid rails style structure.
+// To marshal a map into values, a map with string keys, integer keys or keys
+// implementing encoding.TextMarshaler is required - integers are encoded in
+// base 10, eg. "prices[42]=9.99". Marshal will walk through every key trying
+// to encode values. The values can be structs, pointers to structs, other
+// maps and slices of structs producing a valid rails style structure, eg.
+// map[string]User is encoded as "alice[age]=3&bob[age]=5". A map which is
+// a struct field is encoded the same way under the key of the field, eg.
+// "users[alice][age]=3".
 //
-// Marshal can encode values of types string, int, float, bool.
+// Marshal can encode values of types string, int, float, bool. Values which
+// implement encoding.TextMarshaler, eg. net.IP or big.Int, are encoded as
+// simple values by their MarshalText, also as elements of slices and values
+// of maps. Marshaler takes precedence over encoding.TextMarshaler.
 //
 // Arrays and slices of simple types are easily encoded into []string. However,
 // in case of arrays or slices of structs then every struct's field will have
@@ -102,22 +140,133 @@ func (e *MarshalerError) Error() string {
 //      "as[][Str]": []string{"str_1", "str_2"},
 //    }
 //
+// Nested structs and slices of the elements get their own keys as well, eg.
+// "as[][Nested][Name]" or "as[][Ints][]". Encode writes the arrays of objects
+// element by element, the same way rails' to_query does. However, Values do
+// not keep the order of the pairs, so the elements which have different fields
+// are only encoded correctly by MarshalOrdered.
+//
 // WARNING: if you are willing to encode []interface{} value then keep in mind
 // that in order to be encoded correctly it should contain only primitive types.
 // If a struct or a map will be used as an element then the error will be
 // returned.
 func Marshal(v interface{}) (Values, error) {
-	m, err := (&encoder{}).marshal(reflect.ValueOf(v))
+	return EncoderOptions{}.Marshal(v)
+}
+
+// MarshalOrdered returns v encoded into OrderedValues the same way Marshal
+// does it. The pairs are kept in the order they are encoded - the fields of
+// every struct sorted by key, and the arrays of objects element by element, so
+// that Encode builds the same query string as rails' to_query does.
+func MarshalOrdered(v interface{}) (OrderedValues, error) {
+	return EncoderOptions{}.MarshalOrdered(v)
+}
+
+// EncoderOptions configures the way values are encoded. The zero value encodes
+// values the same way Marshal does.
+type EncoderOptions struct {
+	// Ordered makes the pairs of OrderedValues returned by MarshalOrdered, as
+	// well as the query strings written by MarshalAppend and Encoder, keep the
+	// order of struct fields. By default, the keys of every struct are sorted,
+	// the same way rails sorts them in to_query. Map keys are always sorted.
+	Ordered bool
+
+	// Indexed makes slices of structs encoded as objects keyed by the index of
+	// the element, eg. "users[0][name]=a&users[1][name]=b", the way rails'
+	// nested attributes and jQuery.param send them.
+	Indexed bool
+
+	// Dialect decides how arrays are indexed and how Encode escapes the query
+	// string. It defaults to Rails.
+	Dialect Dialect
+
+	// NumericBools makes bools encoded as "1" and "0", the way rails' check_box
+	// sends them, instead of "true" and "false".
+	NumericBools bool
+
+	// BareNils makes nil pointers and interfaces encoded as nil keys of Values,
+	// which are written without '=', eg. "name", and which rack parses as nil.
+	// By default, they are omitted.
+	BareNils bool
+
+	// EmptyArrays makes empty slices which are not nil encoded as arrays with
+	// a single empty value, eg. "tags[]=", the way rails sends an explicitly
+	// empty array. The slices which are not exploded or are exploded by the
+	// form style are encoded as "tags=". By default, empty slices are omitted.
+	EmptyArrays bool
+}
+
+// Marshal returns v encoded into Values according to the options. See Marshal
+// function for details.
+func (o EncoderOptions) Marshal(v interface{}) (Values, error) {
+	m, err := o.MarshalOrdered(v)
+	return Values{m.Values}, err
+}
+
+// MarshalOrdered returns v encoded into OrderedValues according to the
+// options. See MarshalOrdered function for details.
+func (o EncoderOptions) MarshalOrdered(v interface{}) (OrderedValues, error) {
+	m, err := o.encoder().marshal(reflect.ValueOf(v))
 	if err != nil {
-		return Values{}, err
+		return OrderedValues{}, err
 	}
-	return Values{m}, nil
+	return m, nil
 }
 
-type encoder struct{}
+// Encode encodes the values into the query string escaping the keys and the
+// values according to the dialect of the options.
+func (o EncoderOptions) Encode(v Values) string {
+	return o.EncodeOrdered(OrderedValues{Values: v.Values})
+}
+
+// AppendEncode appends the values encoded the same way Encode does it to dst
+// and returns the extended buffer.
+func (o EncoderOptions) AppendEncode(dst []byte, v Values) []byte {
+	return o.AppendEncodeOrdered(dst, OrderedValues{Values: v.Values})
+}
+
+// EncodeOrdered encodes the ordered values into the query string escaping the
+// keys and the values according to the dialect of the options.
+func (o EncoderOptions) EncodeOrdered(v OrderedValues) string {
+	return string(v.appendEncode(nil, appendEscaper(o.dialect())))
+}
+
+// AppendEncodeOrdered appends the ordered values encoded the same way
+// EncodeOrdered does it to dst and returns the extended buffer.
+func (o EncoderOptions) AppendEncodeOrdered(dst []byte,
+	v OrderedValues) []byte {
+	return v.appendEncode(dst, appendEscaper(o.dialect()))
+}
+
+func (o EncoderOptions) encoder() *encoder {
+	return &encoder{
+		ordered:      o.Ordered,
+		indexed:      o.Indexed,
+		dialect:      o.dialect(),
+		numericBools: o.NumericBools,
+		bareNils:     o.BareNils,
+		emptyArrays:  o.EmptyArrays,
+	}
+}
+
+func (o EncoderOptions) dialect() Dialect {
+	if o.Dialect == nil {
+		return Rails
+	}
+	return o.Dialect
+}
+
+type encoder struct {
+	ordered      bool
+	indexed      bool
+	dialect      Dialect
+	numericBools bool
+	bareNils     bool
+	emptyArrays  bool
+}
 
-func (e *encoder) marshal(v reflect.Value) (m url.Values, err error) {
-	m = make(url.Values)
+func (e *encoder) marshal(v reflect.Value) (m OrderedValues, err error) {
+	m = NewOrderedValues()
 	v = e.indirect(v)
 	if !v.IsValid() {
 		return m, nil
@@ -125,20 +274,23 @@ func (e *encoder) marshal(v reflect.Value) (m url.Values, err error) {
 	if m := e.marshaler(v); m != nil {
 		values, err := m.MarshalQuery()
 		if err != nil {
-			return nil, &MarshalerError{v.Type(), err}
+			return OrderedValues{}, &MarshalerError{v.Type(), err}
 		}
-		return values.Values, nil
+		return OrderedValues{Values: values.Values}, nil
 	}
 	switch v.Kind() {
 	case reflect.Map:
-		err = e.maps(m, v)
+		err = e.maps(&m, v)
 	case reflect.Struct:
-		err = e.object(m, v)
+		err = e.object(&m, v)
 	default:
 		err = &UnsupportedTypeError{v.Type()}
 	}
 	if err != nil {
-		return nil, err
+		return OrderedValues{}, err
+	}
+	if !e.ordered {
+		m.sortKeys()
 	}
 	return m, nil
 }
@@ -164,33 +316,32 @@ func isEmptyValue(v reflect.Value) bool {
 
 // object encodes the given struct. It walks every field ignoring unexported
 // ones and these with the tag "-".
-func (e *encoder) object(values url.Values, v reflect.Value) error {
-	for i := 0; i < v.NumField(); i++ {
-		typ := v.Type().Field(i)
-		if typ.PkgPath != "" && !typ.Anonymous {
-			continue
-		}
-		tag := parseTag(typ)
-		if tag.ignore || tag.omitEmpty && isEmptyValue(v.Field(i)) {
+func (e *encoder) object(values *OrderedValues, v reflect.Value) error {
+	for _, f := range cachedFields(v.Type()).encode {
+		fv := v.Field(f.index)
+		if f.tag.omitEmpty && isEmptyValue(fv) {
 			continue
 		}
-		if typ.Anonymous && tag.empty {
-			if err := e.marshalEmbedded(values, v.Field(i)); err != nil {
+		if f.embedded {
+			if err := e.marshalEmbedded(values, fv); err != nil {
 				return err
 			}
 			continue
 		}
-		if err := e.marshalField(values, v.Field(i), tag); err != nil {
+		if err := e.marshalField(values, fv, f.tag); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (e *encoder) marshalField(values url.Values, v reflect.Value,
+func (e *encoder) marshalField(values *OrderedValues, v reflect.Value,
 	tag tag) error {
 	v = e.indirect(v)
 	if !v.IsValid() {
+		if e.bareNils {
+			values.SetNil(tag.name)
+		}
 		return nil
 	}
 	if m := e.marshaler(v); m != nil {
@@ -198,22 +349,44 @@ func (e *encoder) marshalField(values url.Values, v reflect.Value,
 		if err != nil {
 			return &MarshalerError{v.Type(), err}
 		}
-		e.mergeByKey(tag.name, subm.Values, values)
+		e.mergeByKey(tag.name, OrderedValues{Values: subm.Values}, values)
 		return nil
 	}
-	switch v.Kind() {
-	case reflect.Slice, reflect.Array:
+	if tag.inline && isObjectType(v.Type()) {
+		return e.marshalEmbedded(values, v)
+	}
+	if tag.delim != "" && isObjectType(v.Type()) {
+		return e.joinObject(tag, values, v)
+	}
+	if tag.multiparam && v.Type() == timeType {
+		e.multiparam(tag, values, v)
+		return nil
+	}
+	switch kind := v.Kind(); {
+	case isTextType(v.Type()):
+		str, err := e.conv(v, tag)
+		if err != nil {
+			return err
+		}
+		values.Set(tag.name, str)
+	case kind == reflect.Slice || kind == reflect.Array:
 		if err := e.slices(tag, values, v); err != nil {
 			return err
 		}
-	case reflect.Struct:
+	case kind == reflect.Struct:
 		s, err := e.marshal(v)
 		if err != nil {
 			return err
 		}
 		e.mergeByKey(tag.name, s, values)
+	case kind == reflect.Map:
+		m := NewOrderedValues()
+		if err := e.maps(&m, v); err != nil {
+			return err
+		}
+		e.mergeByKey(tag.name, m, values)
 	default:
-		str, err := e.conv(v)
+		str, err := e.conv(v, tag)
 		if err != nil {
 			return err
 		}
@@ -222,19 +395,43 @@ func (e *encoder) marshalField(values url.Values, v reflect.Value,
 	return nil
 }
 
-func (e *encoder) marshalEmbedded(values url.Values, v reflect.Value) error {
+func (e *encoder) marshalEmbedded(values *OrderedValues,
+	v reflect.Value) error {
 	s, err := e.marshal(v)
 	if err != nil {
 		return err
 	}
-	for k, v := range s {
-		if _, ok := values[k]; !ok {
-			values[k] = v
+	added := make(map[string]bool)
+	for _, p := range s.Pairs() {
+		if _, ok := values.Values[p.Key]; ok && !added[p.Key] {
+			continue
 		}
+		added[p.Key] = true
+		values.add(p.Key, p.Value, p.Value == "" && s.IsNil(p.Key))
 	}
 	return nil
 }
 
+// joinObject encodes the object under a single key as its keys and values
+// joined by the tag's delimiter, eg. "color=R,100,G,200" - the OpenAPI style
+// of objects which are not exploded. The object cannot have nested keys.
+func (e *encoder) joinObject(tag tag, values *OrderedValues,
+	v reflect.Value) error {
+	m, err := e.marshal(v)
+	if err != nil {
+		return err
+	}
+	var strs []string
+	for _, p := range m.Pairs() {
+		if strings.ContainsAny(p.Key, "[]") {
+			return &UnsupportedTypeError{v.Type()}
+		}
+		strs = append(strs, p.Key, p.Value)
+	}
+	values.Set(tag.name, strings.Join(strs, tag.delim))
+	return nil
+}
+
 // mergeByKey merges src Value with dst Value where key serves as an object's
 // name.
 //
@@ -253,131 +450,285 @@ func (e *encoder) marshalEmbedded(values url.Values, v reflect.Value) error {
 //  "bar[name]": []string{"name"},
 // }
 //
-func (e *encoder) mergeByKey(key string, src, dst url.Values) {
-	for k, v := range src {
-		match := reTopKey.FindStringSubmatch(k)
-		if match != nil {
-			dst[fmt.Sprintf("%s[%s]%s", key, match[1], match[2])] = v
+func (e *encoder) mergeByKey(key string, src OrderedValues,
+	dst *OrderedValues) {
+	merged := make(map[string]bool)
+	for _, p := range src.Pairs() {
+		k := key
+		if p.Key != "" {
+			top, rest, ok := splitTop(p.Key)
+			if !ok {
+				continue
+			}
+			k = key + "[" + top + "]" + rest
 		}
-		if k == "" {
-			dst[key] = v
+		if !merged[k] {
+			dst.Del(k)
+			merged[k] = true
 		}
+		dst.add(k, p.Value, p.Value == "" && src.IsNil(p.Key))
 	}
 }
 
-// maps encodes maps into Values. The given map type must have a string as a
-// key.
-func (e *encoder) maps(values url.Values, v reflect.Value) error {
-	if v.Type().Key().Kind() != reflect.String {
-		return &UnsupportedTypeError{v.Type()}
-	}
-	if !v.IsValid() {
-		return nil
+// maps encodes maps into Values. The keys of the map must be strings, integers
+// or implement encoding.TextMarshaler, see mapKeys. Keys are encoded in sorted
+// order. The values which are structs, maps or slices of structs are encoded
+// as nested objects under their keys, eg. "alice[age]" or "admins[][name]".
+func (e *encoder) maps(values *OrderedValues, v reflect.Value) error {
+	keys, err := mapKeys(v)
+	if err != nil {
+		return err
 	}
-	for _, vkey := range v.MapKeys() {
-		vv := e.indirect(v.MapIndex(vkey))
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].s < keys[j].s
+	})
+	for _, key := range keys {
+		vv := e.indirect(v.MapIndex(key.v))
 		if !vv.IsValid() {
+			if e.bareNils {
+				values.SetNil(key.s)
+			}
 			continue
 		}
-		switch vv.Kind() {
-		case reflect.Slice, reflect.Array:
-			if err := e.slices(tag{name: vkey.String()}, values,
+		switch kind := vv.Kind(); {
+		case isTextType(vv.Type()):
+			s, err := e.conv(vv, tag{})
+			if err != nil {
+				return err
+			}
+			values.Set(key.s, s)
+		case kind == reflect.Slice || kind == reflect.Array:
+			if err := e.slices(tag{name: key.s}, values,
 				vv); err != nil {
 				return err
 			}
-		case reflect.Struct:
-			return &UnsupportedTypeError{v.Type()}
-		case reflect.Map:
-			m := make(url.Values)
-			if err := e.maps(m, vv); err != nil {
+		case kind == reflect.Struct:
+			m, err := e.marshal(vv)
+			if err != nil {
+				return err
+			}
+			e.mergeByKey(key.s, m, values)
+		case kind == reflect.Map:
+			m := NewOrderedValues()
+			if err := e.maps(&m, vv); err != nil {
 				return err
 			}
-			e.mergeByKey(vkey.String(), m, values)
+			e.mergeByKey(key.s, m, values)
 		default:
-			s, err := e.conv(vv)
+			s, err := e.conv(vv, tag{})
 			if err != nil {
 				return err
 			}
-			values.Set(vkey.String(), s)
+			values.Set(key.s, s)
 		}
 	}
 	return nil
 }
 
+// mapKey is a key of a map and its string.
+type mapKey struct {
+	s string
+	v reflect.Value
+}
+
+// mapKeys returns the keys of the map with their strings, the same way
+// encoding/json encodes the keys - strings are used as they are, the keys which
+// implement encoding.TextMarshaler are encoded by MarshalText, and integers are
+// encoded in base 10, eg. "prices[42]". Its error is returned as it is. The
+// keys of other types are not supported.
+func mapKeys(v reflect.Value) ([]mapKey, error) {
+	typ := v.Type().Key()
+	switch kind := typ.Kind(); {
+	case kind == reflect.String, typ.Implements(textMarshalerType):
+	case kind >= reflect.Int && kind <= reflect.Uintptr:
+	default:
+		return nil, &UnsupportedTypeError{v.Type()}
+	}
+	keys := make([]mapKey, 0, v.Len())
+	for _, k := range v.MapKeys() {
+		var s string
+		switch kind := k.Kind(); {
+		case kind == reflect.String:
+			s = k.String()
+		case typ.Implements(textMarshalerType):
+			if kind == reflect.Ptr && k.IsNil() {
+				break
+			}
+			b, err := k.Interface().(encoding.TextMarshaler).MarshalText()
+			if err != nil {
+				return nil, err
+			}
+			s = string(b)
+		case kind <= reflect.Int64:
+			s = strconv.FormatInt(k.Int(), 10)
+		default:
+			s = strconv.FormatUint(k.Uint(), 10)
+		}
+		keys = append(keys, mapKey{s, k})
+	}
+	return keys, nil
+}
+
 // structSlices encodes slices of structs by marshaling each one of them.
-// Every struct's field must be encoded to one string, otherwise the final query
-// string will become corrupted. That is why every slice inside the struct will
-// be joined by a comma.
-func (e *encoder) structSlices(tag tag, values url.Values,
+// The pairs of every element are written one after another, so that nested
+// structs and slices keep their rails keys, eg. "orders[][address][city]" or
+// "orders[][items][]". The only exception is a key which is not an array and
+// has many values - eg. returned by a Marshaler - as rails would keep only the
+// last value, the values are joined by a comma.
+//
+// If the encoder is indexed or the dialect indexes arrays of objects, every
+// element is an object keyed by its index, eg. "orders[0][address][city]".
+func (e *encoder) structSlices(tag tag, values *OrderedValues,
 	v reflect.Value) error {
-	m := make(url.Values)
+	m := NewOrderedValues()
 	for i := 0; i < v.Len(); i++ {
 		s, err := e.marshal(v.Index(i))
 		if err != nil {
 			return err
 		}
-		for k, v := range s {
-			m.Add(k, strings.Join(v, ","))
+		index := e.dialect.Index(i, true)
+		if e.indexed {
+			index = strconv.Itoa(i)
 		}
+		if index == "" {
+			e.addElement(&m, s)
+			continue
+		}
+		elem := NewOrderedValues()
+		e.addElement(&elem, s)
+		e.mergeByKey(tag.name+"["+index+"]", elem, values)
 	}
 	e.mergeByKey(tag.name+"[]", m, values)
 	return nil
 }
 
-// slices encodes slices into Values based on the given tag.
-func (e *encoder) slices(tag tag, values url.Values, v reflect.Value) error {
-	el := v.Type().Elem()
-	switch el.Kind() {
-	case reflect.Struct:
-		return e.structSlices(tag, values, v)
-	case reflect.Ptr:
-		if el.Elem().Kind() == reflect.Struct {
-			return e.structSlices(tag, values, v)
+// addElement adds the pairs of the slice element to dst joining the values of
+// the keys which are not arrays.
+func (e *encoder) addElement(dst *OrderedValues, elem OrderedValues) {
+	joined := make(map[string]bool)
+	for _, p := range elem.Pairs() {
+		switch {
+		case strings.Contains(p.Key, "[]"):
+			dst.Add(p.Key, p.Value)
+		case !joined[p.Key]:
+			dst.Add(p.Key, strings.Join(elem.Values[p.Key], ","))
+			joined[p.Key] = true
 		}
-	default:
 	}
+}
+
+// slices encodes slices into Values based on the given tag.
+func (e *encoder) slices(tag tag, values *OrderedValues,
+	v reflect.Value) error {
 	if v.Len() < 1 {
+		if e.isEmptyArray(v) {
+			values.Set(emptyArrayKey(tag), "")
+		}
 		return nil
 	}
+	if isStructSlice(v.Type()) {
+		return e.structSlices(tag, values, v)
+	}
 	var strs []string
 	for i := 0; i < v.Len(); i++ {
 		vv := e.indirect(v.Index(i))
 		if !vv.IsValid() {
 			continue
 		}
-		str, err := e.conv(vv)
+		str, err := e.conv(vv, tag)
 		if err != nil {
 			return err
 		}
 		strs = append(strs, str)
 	}
-	if tag.comma {
-		values.Set(tag.name, strings.Join(strs, ","))
+	if tag.delim != "" {
+		values.Set(tag.name, strings.Join(strs, tag.delim))
 		return nil
 	}
-	values[tag.name+"[]"] = strs
+	if tag.inline {
+		values.Del(tag.name)
+		for _, str := range strs {
+			values.Add(tag.name, str)
+		}
+		return nil
+	}
+	deleted := make(map[string]bool)
+	for i, str := range strs {
+		key := tag.name + "[" + e.dialect.Index(i, false) + "]"
+		if !deleted[key] {
+			values.Del(key)
+			deleted[key] = true
+		}
+		values.Add(key, str)
+	}
 	return nil
 }
 
-// conv encodes simple types into string.
-func (e *encoder) conv(v reflect.Value) (string, error) {
+// conv encodes simple types into string. time.Time and time.Duration are
+// formatted according to the options of the tag.
+func (e *encoder) conv(v reflect.Value, tag tag) (string, error) {
+	switch v.Kind() {
+	case reflect.String:
+		if !isTextType(v.Type()) {
+			return v.String(), nil
+		}
+	case reflect.Struct, reflect.Int64:
+		if b, ok := appendTime(nil, v, tag); ok {
+			return string(b), nil
+		}
+	}
+	b, err := e.appendConv(nil, v)
+	return string(b), err
+}
+
+// appendConv appends simple types encoded into string to dst. It does not
+// format time.Time and time.Duration, which are handled by conv.
+func (e *encoder) appendConv(dst []byte, v reflect.Value) ([]byte, error) {
+	if m := textMarshaler(v); m != nil {
+		text, err := m.MarshalText()
+		if err != nil {
+			return dst, err
+		}
+		return append(dst, text...), nil
+	}
 	switch v.Kind() {
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		return strconv.FormatInt(v.Int(), 10), nil
+		return strconv.AppendInt(dst, v.Int(), 10), nil
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
 		reflect.Uint64:
-		return strconv.FormatUint(v.Uint(), 10), nil
+		return strconv.AppendUint(dst, v.Uint(), 10), nil
 	case reflect.Float32, reflect.Float64:
-		return strconv.FormatFloat(v.Float(), 'f', -1, 64), nil
+		return strconv.AppendFloat(dst, v.Float(), 'f', -1, 64), nil
 	case reflect.String:
-		return v.String(), nil
+		return append(dst, v.String()...), nil
 	case reflect.Bool:
-		return strconv.FormatBool(v.Bool()), nil
+		if !e.numericBools {
+			return strconv.AppendBool(dst, v.Bool()), nil
+		}
+		if v.Bool() {
+			return append(dst, '1'), nil
+		}
+		return append(dst, '0'), nil
 	default:
-		return "", &UnsupportedTypeError{v.Type()}
+		return dst, &UnsupportedTypeError{v.Type()}
 	}
 }
 
+// isEmptyArray reports whether v is an empty slice which is encoded as an array
+// with a single empty value.
+func (e *encoder) isEmptyArray(v reflect.Value) bool {
+	return e.emptyArrays && v.Kind() == reflect.Slice && !v.IsNil()
+}
+
+// emptyArrayKey returns the key of the empty array, eg. "tags[]", or "tags" if
+// the array is not exploded or it is exploded by the form style.
+func emptyArrayKey(tag tag) string {
+	if tag.delim != "" || tag.inline {
+		return tag.name
+	}
+	return tag.name + "[]"
+}
+
 // indirect walks down v, until it gets to a non-pointer.
 func (e *encoder) indirect(v reflect.Value) reflect.Value {
 	for {
@@ -390,6 +741,37 @@ func (e *encoder) indirect(v reflect.Value) reflect.Value {
 	return v
 }
 
+// isStructSlice reports whether the elements of the slice type are structs or
+// pointers to structs, which are encoded as objects.
+func isStructSlice(typ reflect.Type) bool {
+	el := typ.Elem()
+	if el.Kind() == reflect.Ptr {
+		el = el.Elem()
+	}
+	return el.Kind() == reflect.Struct && !isTextType(el)
+}
+
+// textMarshaler returns v as encoding.TextMarshaler if v or its pointer
+// implements it. Otherwise, it returns nil. If only the pointer implements it
+// and v is not addressable, the pointer to a copy of v is returned.
+func textMarshaler(v reflect.Value) encoding.TextMarshaler {
+	if !isTextType(v.Type()) {
+		return nil
+	}
+	if v.Type().Implements(textMarshalerType) {
+		return v.Interface().(encoding.TextMarshaler)
+	}
+	if !reflect.PtrTo(v.Type()).Implements(textMarshalerType) {
+		return nil
+	}
+	if !v.CanAddr() {
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		v = c
+	}
+	return v.Addr().Interface().(encoding.TextMarshaler)
+}
+
 // marshaler checks if v implements marshaler. If not, it returns nil marshaler.
 func (e *encoder) marshaler(v reflect.Value) Marshaler {
 	if v.Type().Implements(marshalerType) {
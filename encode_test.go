@@ -1,10 +1,14 @@
 package railing
 
 import (
+	"errors"
+	"math/big"
+	"net"
 	"net/url"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 type marshalTest []struct {
@@ -352,6 +356,7 @@ func TestMarshal(t *testing.T) {
 	}
 	for i, fixture := range fixtures {
 		out, err := Marshal(fixture.in)
+		testMarshalAppend(t, EncoderOptions{}, fixture.in, i)
 		if !reflect.DeepEqual(fixture.err, err) {
 			t.Errorf("expected err=%v; got %v (i=%d)", fixture.err, err, i)
 			continue
@@ -361,3 +366,805 @@ func TestMarshal(t *testing.T) {
 		}
 	}
 }
+
+// testMarshalAppend checks if MarshalAppend writes the same query string as
+// Encode of the Values returned by MarshalOrdered. Besides o, the options are
+// checked ordered, indexed and with every dialect, so that every fixture of
+// the Marshal tests is written by both encoders in all of their modes.
+func testMarshalAppend(t *testing.T, o EncoderOptions, in interface{}, i int) {
+	t.Helper()
+	options := []EncoderOptions{o, o, o, o, o, o}
+	options[1].Ordered = true
+	options[2].Indexed = true
+	options[3].Dialect = PHP
+	options[4].Dialect = QS
+	options[5].Dialect = JQuery
+	for _, o := range options {
+		v, err := o.MarshalOrdered(in)
+		expected := o.EncodeOrdered(v)
+		out, appendErr := o.MarshalAppend(nil, in)
+		if !reflect.DeepEqual(appendErr, err) {
+			t.Errorf("expected err=%v; got %v (i=%d, o=%+v)", err, appendErr, i,
+				o)
+			continue
+		}
+		if string(out) != expected {
+			t.Errorf("expected %s; got %s (i=%d, o=%+v)", expected, out, i, o)
+		}
+	}
+}
+
+type address struct {
+	City string `railing:"city"`
+}
+
+type item struct {
+	SKU  string   `railing:"sku"`
+	Tags []string `railing:"tags"`
+}
+
+type order struct {
+	ID      int     `railing:"id"`
+	Address address `railing:"address"`
+	Items   []int   `railing:"items"`
+	Lines   []item  `railing:"lines"`
+}
+
+func TestMarshalEncode(t *testing.T) {
+	fixtures := []struct {
+		in  interface{}
+		out string
+	}{
+		// 0
+		{
+			in: struct {
+				Orders []order `railing:"orders"`
+			}{[]order{
+				{ID: 1, Address: address{"NY"}, Items: []int{1, 2}},
+				{ID: 2, Items: []int{3}, Lines: []item{{"a", []string{"x"}},
+					{"b", nil}}},
+			}},
+			out: "orders[][address][city]=NY&orders[][id]=1&orders[][items][]=1" +
+				"&orders[][items][]=2&orders[][address][city]=&orders[][id]=2" +
+				"&orders[][items][]=3&orders[][lines][][sku]=a" +
+				"&orders[][lines][][tags][]=x&orders[][lines][][sku]=b",
+		},
+		// 1
+		{
+			in: struct {
+				Orders []*order `railing:"orders"`
+			}{[]*order{{ID: 1, Lines: []item{{SKU: "a"}}}}},
+			out: "orders[][address][city]=&orders[][id]=1" +
+				"&orders[][lines][][sku]=a",
+		},
+	}
+	for i, fixture := range fixtures {
+		testMarshalAppend(t, EncoderOptions{}, fixture.in, i)
+		v, err := MarshalOrdered(fixture.in)
+		if err != nil {
+			t.Errorf("expected err=nil; got %v (i=%d)", err, i)
+			continue
+		}
+		out, err := url.QueryUnescape(v.Encode())
+		if err != nil {
+			t.Errorf("expected err=nil; got %v (i=%d)", err, i)
+			continue
+		}
+		if out != fixture.out {
+			t.Errorf("expected %s; got %s (i=%d)", fixture.out, out, i)
+		}
+	}
+}
+
+func TestMarshalIndexed(t *testing.T) {
+	in := orders{Orders: []order{
+		{ID: 1, Address: address{"NY"}, Items: []int{1, 2}},
+		{ID: 2, Lines: []item{{SKU: "a"}, {SKU: "b", Tags: []string{"x"}}}},
+	}}
+	expected := "orders[0][address][city]=NY&orders[0][id]=1" +
+		"&orders[0][items][]=1&orders[0][items][]=2" +
+		"&orders[1][address][city]=&orders[1][id]=2" +
+		"&orders[1][lines][0][sku]=a&orders[1][lines][1][sku]=b" +
+		"&orders[1][lines][1][tags][]=x"
+	v, err := EncoderOptions{Indexed: true}.Marshal(in)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %v", err)
+	}
+	out, err := url.QueryUnescape(v.Encode())
+	if err != nil {
+		t.Fatalf("expected err=nil; got %v", err)
+	}
+	if out != expected {
+		t.Errorf("expected %s; got %s", expected, out)
+	}
+	var o orders
+	if err := Unmarshal(v, &o); err != nil {
+		t.Fatalf("expected err=nil; got %v", err)
+	}
+	if !reflect.DeepEqual(o, in) {
+		t.Errorf("expected %v; got %v", in, o)
+	}
+}
+
+type rgb struct {
+	R, G, B int
+}
+
+type styled struct {
+	Form    []int `railing:"form,form"`
+	Color   rgb   `railing:"color,form"`
+	Comma   []int `railing:"comma,form,noexplode"`
+	Space   []int `railing:"space,spaceDelimited"`
+	Pipe    []int `railing:"pipe,pipeDelimited"`
+	PipeExp []int `railing:"pipeExp,pipeDelimited,explode"`
+	Object  rgb   `railing:"object,form,noexplode"`
+	Deep    rgb   `railing:"deep,deepObject"`
+}
+
+func TestMarshalStyles(t *testing.T) {
+	in := styled{
+		Form:    []int{1, 2},
+		Color:   rgb{1, 2, 3},
+		Comma:   []int{1, 2},
+		Space:   []int{1, 2},
+		Pipe:    []int{1, 2},
+		PipeExp: []int{1, 2},
+		Object:  rgb{4, 5, 6},
+		Deep:    rgb{7, 8, 9},
+	}
+	expected := "B=3&G=2&R=1&comma=1,2&deep[B]=9&deep[G]=8&deep[R]=7" +
+		"&form=1&form=2&object=B,6,G,5,R,4&pipe=1|2&pipeExp=1&pipeExp=2" +
+		"&space=1 2"
+	v, err := Marshal(in)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %v", err)
+	}
+	out, err := url.QueryUnescape(v.Encode())
+	if err != nil {
+		t.Fatalf("expected err=nil; got %v", err)
+	}
+	if out != expected {
+		t.Errorf("expected %s; got %s", expected, out)
+	}
+	var s styled
+	if err := Unmarshal(v, &s); err != nil {
+		t.Fatalf("expected err=nil; got %v", err)
+	}
+	if !reflect.DeepEqual(s, in) {
+		t.Errorf("expected %v; got %v", in, s)
+	}
+	_, err = Marshal(struct {
+		Deep styled `railing:"deep,pipeDelimited"`
+	}{})
+	expectedErr := &UnsupportedTypeError{reflect.TypeOf(styled{})}
+	if !reflect.DeepEqual(err, expectedErr) {
+		t.Errorf("expected err=%v; got %v", expectedErr, err)
+	}
+}
+
+// level is an enum encoded as text.
+type level int
+
+func (l level) MarshalText() ([]byte, error) {
+	switch l {
+	case 0:
+		return []byte("low"), nil
+	case 1:
+		return []byte("high & mighty"), nil
+	}
+	return nil, errors.New("unknown level")
+}
+
+func (l *level) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "low":
+		*l = 0
+	case "high & mighty":
+		*l = 1
+	default:
+		return errors.New("unknown level " + string(text))
+	}
+	return nil
+}
+
+type texts struct {
+	IP     net.IP           `railing:"ip"`
+	IPs    []net.IP         `railing:"ips"`
+	Big    big.Int          `railing:"big"`
+	BigPtr *big.Int         `railing:"bigPtr,omitempty"`
+	Level  level            `railing:"level"`
+	Levels map[string]level `railing:"levels,omitempty"`
+	Floats []big.Float      `railing:"floats,comma"`
+}
+
+func TestMarshalText(t *testing.T) {
+	fixtures := []struct {
+		in  interface{}
+		out string
+		err error
+	}{
+		// 0
+		{
+			in: texts{
+				IP:     net.IPv4(127, 0, 0, 1),
+				IPs:    []net.IP{net.ParseIP("::1"), net.IPv4(10, 0, 0, 1)},
+				Big:    *big.NewInt(42),
+				BigPtr: big.NewInt(-1),
+				Level:  1,
+				Floats: []big.Float{*big.NewFloat(1.5), *big.NewFloat(2)},
+			},
+			out: "big=42&bigPtr=-1&floats=1.5%2C2&ip=127.0.0.1&ips%5B%5D=%3A%3A1" +
+				"&ips%5B%5D=10.0.0.1&level=high+%26+mighty",
+		},
+		// 1
+		{
+			in: map[string]interface{}{
+				"ip": net.IPv4(1, 2, 3, 4),
+				"l":  level(1),
+				"n":  big.NewInt(7),
+			},
+			out: "ip=1.2.3.4&l=high+%26+mighty&n=7",
+		},
+		// 2
+		{
+			in:  texts{Level: 2},
+			err: errors.New("unknown level"),
+		},
+	}
+	for i, fixture := range fixtures {
+		v, err := Marshal(fixture.in)
+		testMarshalAppend(t, EncoderOptions{}, fixture.in, i)
+		if !reflect.DeepEqual(err, fixture.err) {
+			t.Errorf("expected err=%v; got %v (i=%d)", fixture.err, err, i)
+			continue
+		}
+		if out := v.Encode(); out != fixture.out {
+			t.Errorf("expected %s; got %s (i=%d)", fixture.out, out, i)
+		}
+	}
+}
+
+type times struct {
+	From    time.Time      `railing:"from"`
+	To      time.Time      `railing:"to,layout=2006-01-02"`
+	TS      time.Time      `railing:"ts,unix"`
+	Days    []time.Time    `railing:"days,layout=2006-01-02"`
+	Timeout time.Duration  `railing:"timeout"`
+	Wait    *time.Duration `railing:"wait,seconds,omitempty"`
+}
+
+func TestMarshalTime(t *testing.T) {
+	wait, negWait := 1500*time.Millisecond, -1500*time.Millisecond
+	fixtures := []struct {
+		in  interface{}
+		out string
+	}{
+		// 0
+		{
+			in: times{
+				From: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+				To:   time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC),
+				TS:   time.Unix(1700000000, 5e8).UTC(),
+				Days: []time.Time{
+					time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+					time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+				},
+				Timeout: 90 * time.Second,
+				Wait:    &wait,
+			},
+			out: "days%5B%5D=2024-01-01&days%5B%5D=2024-01-02" +
+				"&from=2024-01-02T03%3A04%3A05Z&timeout=1m30s&to=2024-01-31" +
+				"&ts=1700000000.5&wait=1.5",
+		},
+		// 1
+		{
+			in: times{},
+			out: "from=0001-01-01T00%3A00%3A00Z&timeout=0s&to=0001-01-01" +
+				"&ts=-62135596800",
+		},
+		// 2
+		{
+			in: times{
+				TS:      time.Unix(-2, 25e7).UTC(),
+				Timeout: 1500 * time.Nanosecond,
+				Wait:    &negWait,
+			},
+			out: "from=0001-01-01T00%3A00%3A00Z&timeout=1.5%C2%B5s" +
+				"&to=0001-01-01&ts=-1.75&wait=-1.5",
+		},
+		// 3
+		{
+			in:  map[string]interface{}{"d": 2 * time.Second},
+			out: "d=2s",
+		},
+	}
+	for i, fixture := range fixtures {
+		v, err := Marshal(fixture.in)
+		testMarshalAppend(t, EncoderOptions{}, fixture.in, i)
+		if err != nil {
+			t.Errorf("expected err=nil; got %v (i=%d)", err, i)
+			continue
+		}
+		if out := v.Encode(); out != fixture.out {
+			t.Errorf("expected %s; got %s (i=%d)", fixture.out, out, i)
+		}
+	}
+}
+
+type post struct {
+	Title       string     `railing:"title"`
+	PublishedAt time.Time  `railing:"published_at,multiparam"`
+	Due         *time.Time `railing:"due,multiparam,omitempty"`
+}
+
+type postForm struct {
+	Post post `railing:"post"`
+}
+
+func TestMarshalMultiparam(t *testing.T) {
+	due := time.Date(2024, 6, 1, 0, 0, 30, 0, time.UTC)
+	fixtures := []struct {
+		in  interface{}
+		out string
+	}{
+		// 0
+		{
+			in: post{
+				Title:       "a",
+				PublishedAt: time.Date(2024, 5, 17, 10, 30, 0, 0, time.UTC),
+			},
+			out: "published_at%281i%29=2024&published_at%282i%29=5" +
+				"&published_at%283i%29=17&published_at%284i%29=10" +
+				"&published_at%285i%29=30&title=a",
+		},
+		// 1
+		{
+			in: postForm{post{PublishedAt: time.Date(2024, 5, 17, 10, 30, 0, 0,
+				time.UTC), Due: &due}},
+			out: "post%5Bdue%281i%29%5D=2024&post%5Bdue%282i%29%5D=6" +
+				"&post%5Bdue%283i%29%5D=1&post%5Bdue%284i%29%5D=0" +
+				"&post%5Bdue%285i%29%5D=0&post%5Bdue%286i%29%5D=30" +
+				"&post%5Bpublished_at%281i%29%5D=2024" +
+				"&post%5Bpublished_at%282i%29%5D=5" +
+				"&post%5Bpublished_at%283i%29%5D=17" +
+				"&post%5Bpublished_at%284i%29%5D=10" +
+				"&post%5Bpublished_at%285i%29%5D=30&post%5Btitle%5D=",
+		},
+		// 2
+		{
+			in: post{PublishedAt: time.Date(2024, 5, 17, 1, 30, 0, 0,
+				time.FixedZone("CEST", 2*60*60))},
+			out: "published_at%281i%29=2024&published_at%282i%29=5" +
+				"&published_at%283i%29=16&published_at%284i%29=23" +
+				"&published_at%285i%29=30&title=",
+		},
+	}
+	for i, fixture := range fixtures {
+		v, err := Marshal(fixture.in)
+		testMarshalAppend(t, EncoderOptions{}, fixture.in, i)
+		if err != nil {
+			t.Errorf("expected err=nil; got %v (i=%d)", err, i)
+			continue
+		}
+		if out := v.Encode(); out != fixture.out {
+			t.Errorf("expected %s; got %s (i=%d)", fixture.out, out, i)
+		}
+	}
+}
+
+func TestMarshalNumericBools(t *testing.T) {
+	yes := true
+	fixtures := []struct {
+		o   EncoderOptions
+		in  interface{}
+		out string
+	}{
+		// 0
+		{
+			o:   EncoderOptions{NumericBools: true},
+			in:  checkBoxes{Admin: true, Terms: &yes, Rating: []bool{false, true}},
+			out: "admin=1&name=&rating%5B%5D=0&rating%5B%5D=1&terms=1",
+		},
+		// 1
+		{
+			o:   EncoderOptions{NumericBools: true},
+			in:  map[string]interface{}{"a": false, "b": true},
+			out: "a=0&b=1",
+		},
+		// 2
+		{
+			in:  checkBoxes{Admin: true},
+			out: "admin=true&name=",
+		},
+	}
+	for i, fixture := range fixtures {
+		v, err := fixture.o.Marshal(fixture.in)
+		testMarshalAppend(t, fixture.o, fixture.in, i)
+		if err != nil {
+			t.Errorf("expected err=nil; got %v (i=%d)", err, i)
+			continue
+		}
+		if out := fixture.o.Encode(v); out != fixture.out {
+			t.Errorf("expected %s; got %s (i=%d)", fixture.out, out, i)
+		}
+	}
+}
+
+type nullable struct {
+	Name    *string     `railing:"name"`
+	Title   *string     `railing:"title"`
+	Note    interface{} `railing:"note"`
+	Age     int         `railing:"age"`
+	Address *address    `railing:"address"`
+	Skip    *string     `railing:"skip,omitempty"`
+}
+
+func TestMarshalBareNils(t *testing.T) {
+	empty := ""
+	fixtures := []struct {
+		o   EncoderOptions
+		in  interface{}
+		out string
+	}{
+		// 0
+		{
+			o:   EncoderOptions{BareNils: true},
+			in:  nullable{Title: &empty},
+			out: "address&age=0&name&note&title=",
+		},
+		// 1
+		{
+			in:  nullable{Title: &empty},
+			out: "age=0&title=",
+		},
+		// 2
+		{
+			o:  EncoderOptions{BareNils: true, Ordered: true},
+			in: struct{ User nullable }{nullable{Name: &empty}},
+			out: "User%5Bname%5D=&User%5Btitle%5D&User%5Bnote%5D&User%5Bage%5D=0" +
+				"&User%5Baddress%5D",
+		},
+		// 3
+		{
+			o:   EncoderOptions{BareNils: true},
+			in:  map[string]interface{}{"a": nil, "b": "", "c": (*int)(nil)},
+			out: "a&b=&c",
+		},
+		// 4
+		{
+			o:  EncoderOptions{BareNils: true},
+			in: struct{ B []nullable }{[]nullable{{Age: 1}}},
+			out: "B%5B%5D%5Baddress%5D=&B%5B%5D%5Bage%5D=1&B%5B%5D%5Bname%5D=" +
+				"&B%5B%5D%5Bnote%5D=&B%5B%5D%5Btitle%5D=",
+		},
+	}
+	for i, fixture := range fixtures {
+		v, err := fixture.o.MarshalOrdered(fixture.in)
+		testMarshalAppend(t, fixture.o, fixture.in, i)
+		if err != nil {
+			t.Errorf("expected err=nil; got %v (i=%d)", err, i)
+			continue
+		}
+		if out := fixture.o.EncodeOrdered(v); out != fixture.out {
+			t.Errorf("expected %s; got %s (i=%d)", fixture.out, out, i)
+		}
+	}
+}
+
+type tagged struct {
+	Tags  []string `railing:"tags"`
+	IDs   []int    `railing:"ids,comma"`
+	Names []string `railing:"names,omitempty"`
+	Items []item   `railing:"items"`
+}
+
+func TestMarshalEmptyArrays(t *testing.T) {
+	fixtures := []struct {
+		o   EncoderOptions
+		in  interface{}
+		out string
+	}{
+		// 0
+		{
+			o:   EncoderOptions{EmptyArrays: true},
+			in:  tagged{Tags: []string{}, IDs: []int{}, Names: []string{}},
+			out: "ids=&tags%5B%5D=",
+		},
+		// 1
+		{
+			in:  tagged{Tags: []string{}, IDs: []int{}, Names: []string{}},
+			out: "",
+		},
+		// 2
+		{
+			o:   EncoderOptions{EmptyArrays: true},
+			in:  tagged{Items: []item{}},
+			out: "items%5B%5D=",
+		},
+		// 3
+		{
+			o:   EncoderOptions{EmptyArrays: true, Ordered: true},
+			in:  struct{ Post tagged }{tagged{Tags: []string{}}},
+			out: "Post%5Btags%5D%5B%5D=",
+		},
+		// 4
+		{
+			o:   EncoderOptions{EmptyArrays: true},
+			in:  map[string]interface{}{"a": []int{}, "b": []int(nil)},
+			out: "a%5B%5D=",
+		},
+	}
+	for i, fixture := range fixtures {
+		v, err := fixture.o.Marshal(fixture.in)
+		testMarshalAppend(t, fixture.o, fixture.in, i)
+		if err != nil {
+			t.Errorf("expected err=nil; got %v (i=%d)", err, i)
+			continue
+		}
+		if out := fixture.o.Encode(v); out != fixture.out {
+			t.Errorf("expected %s; got %s (i=%d)", fixture.out, out, i)
+		}
+	}
+}
+
+type mapFields struct {
+	Users map[string]address  `railing:"users"`
+	ID    int                 `railing:"id"`
+	Tags  map[string][]string `railing:"tags,omitempty"`
+}
+
+func TestMarshalMaps(t *testing.T) {
+	fixtures := []struct {
+		o   EncoderOptions
+		in  interface{}
+		out string
+	}{
+		// 0
+		{
+			in:  map[string]address{"bob": {"Rome"}, "alice": {"Paris"}},
+			out: "alice%5Bcity%5D=Paris&bob%5Bcity%5D=Rome",
+		},
+		// 1
+		{
+			in:  map[string]*address{"alice": {"Paris"}, "bob": nil},
+			out: "alice%5Bcity%5D=Paris",
+		},
+		// 2
+		{
+			in: map[string][]item{
+				"admins": {{SKU: "a", Tags: []string{"x"}}, {SKU: "b"}},
+			},
+			out: "admins%5B%5D%5Bsku%5D=a&admins%5B%5D%5Btags%5D%5B%5D=x" +
+				"&admins%5B%5D%5Bsku%5D=b",
+		},
+		// 3
+		{
+			o: EncoderOptions{Indexed: true},
+			in: map[string][]*address{
+				"users": {{"Paris"}, {"Rome"}},
+			},
+			out: "users%5B0%5D%5Bcity%5D=Paris&users%5B1%5D%5Bcity%5D=Rome",
+		},
+		// 4
+		{
+			in: map[string]interface{}{
+				"filter": map[string]interface{}{
+					"order": order{ID: 1, Address: address{"Paris"}},
+				},
+				"page": 2,
+			},
+			out: "filter%5Border%5D%5Baddress%5D%5Bcity%5D=Paris" +
+				"&filter%5Border%5D%5Bid%5D=1&page=2",
+		},
+		// 5
+		{
+			in:  map[string]*joinedStr{"j": {"1,2"}},
+			out: "j%5BStr%5D=1&j%5BStr%5D=2",
+		},
+		// 6
+		{
+			o:   EncoderOptions{Ordered: true},
+			in:  map[string]order{"a": {ID: 1, Address: address{"Paris"}}},
+			out: "a%5Bid%5D=1&a%5Baddress%5D%5Bcity%5D=Paris",
+		},
+		// 7
+		{
+			in: mapFields{
+				ID:    1,
+				Users: map[string]address{"bob": {"Rome"}, "alice": {"Paris"}},
+				Tags:  map[string][]string{"a": {"x", "y"}},
+			},
+			out: "id=1&tags%5Ba%5D%5B%5D=x&tags%5Ba%5D%5B%5D=y" +
+				"&users%5Balice%5D%5Bcity%5D=Paris&users%5Bbob%5D%5Bcity%5D=Rome",
+		},
+		// 8
+		{
+			o: EncoderOptions{Ordered: true},
+			in: mapFields{
+				ID:    1,
+				Users: map[string]address{"bob": {"Rome"}, "alice": {"Paris"}},
+			},
+			out: "users%5Balice%5D%5Bcity%5D=Paris&users%5Bbob%5D%5Bcity%5D=Rome" +
+				"&id=1",
+		},
+	}
+	for i, fixture := range fixtures {
+		v, err := fixture.o.MarshalOrdered(fixture.in)
+		testMarshalAppend(t, fixture.o, fixture.in, i)
+		if err != nil {
+			t.Errorf("expected err=nil; got %v (i=%d)", err, i)
+			continue
+		}
+		if out := fixture.o.EncodeOrdered(v); out != fixture.out {
+			t.Errorf("expected %s; got %s (i=%d)", fixture.out, out, i)
+		}
+	}
+}
+
+func TestMapFieldsRoundTrip(t *testing.T) {
+	inputs := []interface{}{
+		// 0
+		mapFields{
+			ID:    1,
+			Users: map[string]address{"alice": {"Paris"}, "bob": {"Rome"}},
+			Tags:  map[string][]string{"a": {"x", "y"}, "b": {"z"}},
+		},
+		// 1
+		keyedFields{
+			Prices: map[int]float64{42: 9.99, -1: 1},
+			Levels: map[level]address{0: {"Paris"}, 1: {"Rome"}},
+		},
+	}
+	for i, in := range inputs {
+		v, err := Marshal(in)
+		if err != nil {
+			t.Errorf("expected err=nil; got %v (i=%d)", err, i)
+			continue
+		}
+		m, err := ParseQuery(v.Encode())
+		if err != nil {
+			t.Errorf("expected err=nil; got %v (i=%d)", err, i)
+			continue
+		}
+		out := reflect.New(reflect.TypeOf(in))
+		if err := Unmarshal(m, out.Interface()); err != nil {
+			t.Errorf("expected err=nil; got %v (i=%d)", err, i)
+			continue
+		}
+		if !reflect.DeepEqual(out.Elem().Interface(), in) {
+			t.Errorf("expected %v; got %v (i=%d)", in, out.Elem().Interface(), i)
+		}
+	}
+}
+
+type keyedFields struct {
+	Prices map[int]float64   `railing:"prices"`
+	Levels map[level]address `railing:"levels"`
+}
+
+func TestMarshalMapKeys(t *testing.T) {
+	fixtures := []struct {
+		in  interface{}
+		out string
+		err error
+	}{
+		// 0
+		{
+			in:  map[int]float64{42: 9.99, -1: 1, 100: 2},
+			out: "-1=1&100=2&42=9.99",
+		},
+		// 1
+		{
+			in: map[string]interface{}{
+				"prices": map[uint8][]int{7: {1, 2}},
+			},
+			out: "prices%5B7%5D%5B%5D=1&prices%5B7%5D%5B%5D=2",
+		},
+		// 2
+		{
+			in:  map[level]address{0: {"Paris"}, 1: {"Rome"}},
+			out: "high+%26+mighty%5Bcity%5D=Rome&low%5Bcity%5D=Paris",
+		},
+		// 3
+		{
+			in:  map[level]int{2: 1},
+			err: errors.New("unknown level"),
+		},
+		// 4
+		{
+			in:  map[bool]int{true: 1},
+			err: &UnsupportedTypeError{reflect.TypeOf(map[bool]int{})},
+		},
+		// 5
+		{
+			in:  keyedFields{Prices: map[int]float64{42: 9.99}},
+			out: "prices%5B42%5D=9.99",
+		},
+		// 6
+		{
+			in:  keyedFields{Levels: map[level]address{1: {"Rome"}}},
+			out: "levels%5Bhigh+%26+mighty%5D%5Bcity%5D=Rome",
+		},
+		// 7
+		{
+			in:  keyedFields{Levels: map[level]address{2: {"Rome"}}},
+			err: errors.New("unknown level"),
+		},
+	}
+	for i, fixture := range fixtures {
+		v, err := Marshal(fixture.in)
+		if !reflect.DeepEqual(fixture.err, err) {
+			t.Errorf("expected err=%v; got %v (i=%d)", fixture.err, err, i)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		testMarshalAppend(t, EncoderOptions{}, fixture.in, i)
+		if out := v.Encode(); out != fixture.out {
+			t.Errorf("expected %s; got %s (i=%d)", fixture.out, out, i)
+		}
+	}
+}
+
+type formIDs struct {
+	IDs []int `railing:"ids,form"`
+}
+
+type formElem struct {
+	In  formIDs `railing:"in"`
+	IDs []int   `railing:"ids,form"`
+}
+
+func TestMarshalAppend(t *testing.T) {
+	inputs := []interface{}{
+		// 0
+		styled{Form: []int{1, 2}, Color: rgb{1, 2, 3}, Pipe: []int{1, 2}},
+		// 1
+		map[string]interface{}{
+			"b": []string{"x y", "z"},
+			"a": map[string]interface{}{"c": 1, "b": "&"},
+		},
+		// 2
+		struct {
+			Els []joinedStr `railing:"els"`
+			M   *joinedStr  `railing:"m"`
+		}{[]joinedStr{{"a,b"}, {"c"}}, &joinedStr{"d,e"}},
+		// 3
+		orders{Orders: []order{
+			{ID: 1, Address: address{"New York"}, Items: []int{1, 2}},
+			{ID: 2, Lines: []item{{SKU: "a"}, {SKU: "b~", Tags: []string{"x"}}}},
+		}},
+		// 4
+		struct {
+			A *string                  `railing:"a"`
+			B []nullable               `railing:"b"`
+			C []map[string]interface{} `railing:"c"`
+		}{B: []nullable{{}, {Age: 1}}, C: []map[string]interface{}{{"d": nil}}},
+		// 5
+		struct {
+			Elems []formElem `railing:"elems"`
+		}{[]formElem{{formIDs{[]int{3, 4}}, []int{5, 6}}, {IDs: []int{7}}}},
+	}
+	for i, in := range inputs {
+		testMarshalAppend(t, EncoderOptions{}, in, i)
+		testMarshalAppend(t, EncoderOptions{BareNils: true}, in, i)
+	}
+
+	dst, err := MarshalAppend([]byte("/path?"), order{ID: 1})
+	if err != nil {
+		t.Fatalf("expected err=nil; got %v", err)
+	}
+	expected := "/path?address%5Bcity%5D=&id=1"
+	if string(dst) != expected {
+		t.Errorf("expected %s; got %s", expected, dst)
+	}
+	dst, err = MarshalAppend([]byte("/path?"), struct{ Ch chan int }{})
+	if err == nil || string(dst) != "/path?" {
+		t.Errorf("expected an error and unchanged dst; got %v, %s", err, dst)
+	}
+	v := NewValues(Pair{"a b", "c&d"}, Pair{"e", ""})
+	if out := string(v.AppendEncode([]byte("q="))); out != "q=a+b=c%26d&e=" {
+		t.Errorf("expected q=a+b=c%%26d&e=; got %s", out)
+	}
+}
@@ -15,7 +15,7 @@ type Marshaler struct {
 }
 
 func (p *Marshaler) MarshalQuery() (railing.Values, error) {
-	return railing.Values{Values: url.Values{
+	return railing.Values{url.Values{
 		"marshaler": []string{fmt.Sprintf("%d:%s:%s", p.ID, p.Name, p.City)},
 	}}, nil
 }
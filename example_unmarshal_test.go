@@ -15,7 +15,7 @@ func ExampleUnmarshal() {
 		Palette []color.RGBA
 	}
 
-	values := railing.Values{Values: url.Values{
+	values := railing.Values{url.Values{
 		"ID":           {"1"},
 		"Palette[][R]": {"255", "0"},
 		"Palette[][G]": {"0", "255"},
@@ -34,7 +34,7 @@ func ExampleUnmarshal() {
 }
 
 func ExampleUnmarshal_maps() {
-	values := railing.Values{Values: url.Values{
+	values := railing.Values{url.Values{
 		"first_array": {"1", "2"},
 	}}
 
@@ -49,7 +49,7 @@ func ExampleUnmarshal_maps() {
 }
 
 func ExampleUnmarshal_interface() {
-	values := railing.Values{Values: url.Values{
+	values := railing.Values{url.Values{
 		"person[name]": {"bob"},
 	}}
 
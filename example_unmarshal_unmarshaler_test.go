@@ -24,7 +24,7 @@ func (u *Unmarshaler) UnmarshalQuery(v railing.Values) error {
 }
 
 func ExampleUnmarshal_unmarshaler() {
-	values := railing.Values{Values: url.Values{
+	values := railing.Values{url.Values{
 		"unmarshaler": {"Bob:NY"},
 	}}
 
@@ -0,0 +1,40 @@
+//go:build go1.18
+// +build go1.18
+
+package railing
+
+import "reflect"
+
+// UnmarshalAs unmarshals the Values into a new value of type T and returns it.
+// It works the same way as Unmarshal, but the type of the result is checked at
+// compile time.
+//
+//	user, err := railing.UnmarshalAs[User](values)
+func UnmarshalAs[T any](m Values) (T, error) {
+	var v T
+	err := Unmarshal(m, &v)
+	return v, err
+}
+
+// Get unmarshals the value of the key into a new value of type T and returns
+// it. The key may point to a nested value, eg. "user[address][zip]", which is
+// decoded the same way Unmarshal decodes a struct field of the type T. If the
+// Values do not contain the key, Get returns the zero value of T.
+//
+//	zip, err := railing.Get[string](values, "user[address][zip]")
+//	tags, err := railing.Get[[]string](values, "user[tags][]")
+func Get[T any](m Values, key string) (T, error) {
+	var v T
+	segments := splitSegments(key)
+	if n := len(segments); n > 1 && segments[n-1] == "" {
+		segments = segments[:n-1]
+	}
+	o := OrderedValues{Values: m.Values}.clone()
+	for _, seg := range segments[:len(segments)-1] {
+		o = o.Object(seg)
+	}
+	name := segments[len(segments)-1]
+	err := (&decoder{}).field(newKeyIndex(o), tag{name: name},
+		reflect.ValueOf(&v).Elem())
+	return v, err
+}
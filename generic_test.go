@@ -0,0 +1,118 @@
+//go:build go1.18
+// +build go1.18
+
+package railing
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUnmarshalAs(t *testing.T) {
+	m, err := ParseQuery("id=1&address[city]=NY&items[]=1&items[]=2")
+	if err != nil {
+		t.Fatalf("expected err=nil; got %v", err)
+	}
+	out, err := UnmarshalAs[order](m)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %v", err)
+	}
+	expected := order{ID: 1, Address: address{"NY"}, Items: []int{1, 2}}
+	if !reflect.DeepEqual(out, expected) {
+		t.Errorf("expected %v; got %v", expected, out)
+	}
+
+	ptr, err := UnmarshalAs[*order](m)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %v", err)
+	}
+	if !reflect.DeepEqual(*ptr, expected) {
+		t.Errorf("expected %v; got %v", expected, *ptr)
+	}
+
+	if _, err := UnmarshalAs[int](m); err == nil {
+		t.Error("expected err != nil; got nil")
+	}
+}
+
+func TestGet(t *testing.T) {
+	m, err := ParseQuery("id=1&user[address][zip]=10001&user[tags][]=a" +
+		"&user[tags][]=b&orders[][id]=1&orders[][id]=2&price=x")
+	if err != nil {
+		t.Fatalf("expected err=nil; got %v", err)
+	}
+	type orderID struct {
+		ID int `railing:"id"`
+	}
+	fixtures := []struct {
+		get      func() (interface{}, error)
+		expected interface{}
+		err      error
+	}{
+		// 0
+		{
+			get:      func() (interface{}, error) { return Get[int](m, "id") },
+			expected: 1,
+		},
+		// 1
+		{
+			get: func() (interface{}, error) {
+				return Get[string](m, "user[address][zip]")
+			},
+			expected: "10001",
+		},
+		// 2
+		{
+			get: func() (interface{}, error) {
+				return Get[[]string](m, "user[tags][]")
+			},
+			expected: []string{"a", "b"},
+		},
+		// 3
+		{
+			get: func() (interface{}, error) {
+				return Get[[]string](m, "user[tags]")
+			},
+			expected: []string{"a", "b"},
+		},
+		// 4
+		{
+			get: func() (interface{}, error) {
+				return Get[map[string]string](m, "user[address]")
+			},
+			expected: map[string]string{"zip": "10001"},
+		},
+		// 5
+		{
+			get: func() (interface{}, error) {
+				return Get[[]orderID](m, "orders")
+			},
+			expected: []orderID{{1}, {2}},
+		},
+		// 6
+		{
+			get: func() (interface{}, error) {
+				return Get[*int](m, "missing[id]")
+			},
+			expected: (*int)(nil),
+		},
+		// 7
+		{
+			get:      func() (interface{}, error) { return Get[float64](m, "price") },
+			expected: float64(0),
+			err:      &UnmarshalTypeError{"number x", reflect.TypeOf(0.0)},
+		},
+	}
+	for i, fixture := range fixtures {
+		out, err := fixture.get()
+		if !reflect.DeepEqual(err, fixture.err) {
+			t.Errorf("expected err=%v; got %v (i=%d)", fixture.err, err, i)
+		}
+		if !reflect.DeepEqual(out, fixture.expected) {
+			t.Errorf("expected %v; got %v (i=%d)", fixture.expected, out, i)
+		}
+	}
+	if m.Get("id") != "1" {
+		t.Errorf("expected Get not to modify the Values; got %v", m.Values)
+	}
+}
@@ -0,0 +1,167 @@
+package gentest
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jszwec/railing"
+)
+
+// The plain types have the same fields as the generated ones but no methods,
+// so they are encoded and decoded by reflection. The nested types of
+// plainOrder still use their generated methods, which are tested on their own.
+type (
+	plainOrder   Order
+	plainAddress Address
+	plainLine    Line
+)
+
+func TestMarshalQuery(t *testing.T) {
+	count, prio := uint16(3), Level(1)
+	fixtures := []struct {
+		in    interface{}
+		plain interface{}
+	}{
+		// 0
+		{Order{}, plainOrder{}},
+		// 1
+		{
+			in: Order{
+				ID: 1, Name: "a b", Status: "new", Price: 1.5, Rate: 0.25,
+				Paid: true, Count: &count, Tags: []string{"x", "y"},
+				Items: []int{1, 2}, Codes: []Status{"c"},
+				Address: Address{City: "NY", Zip: "10001"},
+				Billing: &Address{City: "LA"},
+				Lines:   []Line{{SKU: "a", Qty: 1}, {SKU: "b"}},
+				Gifts:   []*Line{{SKU: "g"}, nil},
+				Note:    "note", Weight: 7, secret: "s",
+				Level: 1, Levels: []Level{0, 1}, Prio: &prio,
+				Code: Code{"A", "1"}, Codes2: []Code{{"B", "2"}},
+				Carrier: &Carrier{Name: "ups"},
+			},
+		},
+		// 2
+		{Address{City: "NY"}, plainAddress{City: "NY"}},
+		// 3
+		{Line{SKU: "a", Qty: -1}, plainLine{SKU: "a", Qty: -1}},
+		// 4
+		{Order{Levels: []Level{2}}, plainOrder{Levels: []Level{2}}},
+	}
+	fixtures[1].plain = plainOrder(fixtures[1].in.(Order))
+	for i, fixture := range fixtures {
+		out, err := railing.Marshal(fixture.in)
+		if e, ok := err.(*railing.MarshalerError); ok {
+			err = e.Err
+		}
+		expected, expectedErr := railing.Marshal(fixture.plain)
+		if !reflect.DeepEqual(err, expectedErr) {
+			t.Errorf("expected err=%v; got %v (i=%d)", expectedErr, err, i)
+			continue
+		}
+		if !reflect.DeepEqual(out.Pairs(), expected.Pairs()) {
+			t.Errorf("expected %v; got %v (i=%d)", expected.Pairs(), out.Pairs(), i)
+		}
+	}
+}
+
+func TestUnmarshalQuery(t *testing.T) {
+	fixtures := []string{
+		// 0
+		"",
+		// 1
+		"id=1&name=a&status=new&price=1.5&rate=0.25&paid=true&count=3" +
+			"&tags=x,y&items[]=1&items[]=2&codes[]=c&address[city]=NY" +
+			"&billing[zip]=1&lines[][sku]=a&lines[][qty]=1&lines[][sku]=b" +
+			"&gifts[][sku]=g&Weight=7&carrier[name]=ups",
+		// 2
+		"id=&price=&paid=&count=&items[]=&tags=&codes[]=",
+		// 3
+		"items[1]=2&items[0]=1&codes[0]=a&lines[1][sku]=b&lines[0][sku]=a",
+		// 4
+		"id[]=1&name[]=a&tags[]=x,y",
+		// 5
+		"id=x",
+		// 6
+		"rate=",
+		// 7
+		"count=70000",
+		// 8
+		"paid=maybe",
+		// 9
+		"items[]=a",
+		// 10
+		"items[0][x]=1",
+		// 11
+		"lines[][qty]=x",
+		// 12
+		"Weight=-1",
+		// 13
+		"level=high&levels=low,high&prio=high&code=A-1&codes2[]=B-2" +
+			"&codes2[]=C-3",
+		// 14
+		"level=&prio=&code=&codes2[0]=D-4",
+		// 15
+		"levels=low,",
+		// 16
+		"code=bad",
+		// 17
+		"lines[-1][qty]=1",
+		// 18
+		"codes2[-1]=D-4",
+	}
+	for i, query := range fixtures {
+		m, err := railing.ParseQuery(query)
+		if err != nil {
+			t.Errorf("expected err=nil; got %v (i=%d)", err, i)
+			continue
+		}
+		var out Order
+		var expected plainOrder
+		err = railing.Unmarshal(m, &out)
+		expectedErr := railing.Unmarshal(m, &expected)
+		if !reflect.DeepEqual(err, expectedErr) {
+			t.Errorf("expected err=%v; got %v (i=%d)", expectedErr, err, i)
+		}
+		if !reflect.DeepEqual(out, Order(expected)) {
+			t.Errorf("expected %v; got %v (i=%d)", expected, out, i)
+		}
+	}
+}
+
+func TestUnmarshalQueryNested(t *testing.T) {
+	fixtures := []string{
+		// 0
+		"city=NY&zip=1",
+		// 1
+		"city[]=NY&zip",
+		// 2
+		"sku=a&qty=1",
+		// 3
+		"qty=1.5",
+	}
+	for i, query := range fixtures {
+		m, err := railing.ParseQuery(query)
+		if err != nil {
+			t.Errorf("expected err=nil; got %v (i=%d)", err, i)
+			continue
+		}
+		var addr Address
+		var plainAddr plainAddress
+		if err, expected := railing.Unmarshal(m, &addr),
+			railing.Unmarshal(m, &plainAddr); !reflect.DeepEqual(err, expected) {
+			t.Errorf("expected err=%v; got %v (i=%d)", expected, err, i)
+		}
+		if addr != Address(plainAddr) {
+			t.Errorf("expected %v; got %v (i=%d)", plainAddr, addr, i)
+		}
+		var line Line
+		var plain plainLine
+		if err, expected := railing.Unmarshal(m, &line),
+			railing.Unmarshal(m, &plain); !reflect.DeepEqual(err, expected) {
+			t.Errorf("expected err=%v; got %v (i=%d)", expected, err, i)
+		}
+		if line != Line(plain) {
+			t.Errorf("expected %v; got %v (i=%d)", plain, line, i)
+		}
+	}
+}
@@ -0,0 +1,553 @@
+// Code generated by railinggen -type=Order,Address,Line; DO NOT EDIT.
+
+package gentest
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/jszwec/railing"
+)
+
+// MarshalQuery implements railing.Marshaler.
+func (v Order) MarshalQuery() (railing.Values, error) {
+	m := railing.NewValues()
+	m.Set("Weight", strconv.FormatUint(uint64(v.Weight), 10))
+	{
+		obj, err := v.Address.MarshalQuery()
+		if err != nil {
+			return railing.Values{}, &railing.MarshalerError{Type: reflect.TypeOf(Address{}), Err: err}
+		}
+		m.SetObject("address", obj)
+	}
+	if v.Billing != nil {
+		obj, err := v.Billing.MarshalQuery()
+		if err != nil {
+			return railing.Values{}, &railing.MarshalerError{Type: reflect.TypeOf(Address{}), Err: err}
+		}
+		m.SetObject("billing", obj)
+	}
+	if v.Carrier != nil {
+		obj, err := railing.Marshal(v.Carrier)
+		if err != nil {
+			return railing.Values{}, err
+		}
+		m.SetObject("carrier", obj)
+	}
+	{
+		text, err := v.Code.MarshalText()
+		if err != nil {
+			return railing.Values{}, err
+		}
+		m.Set("code", string(text))
+	}
+	if len(v.Codes) > 0 {
+		for _, x := range v.Codes {
+			m.Add("codes[]", string(x))
+		}
+	}
+	if len(v.Codes2) > 0 {
+		for _, x := range v.Codes2 {
+			text, err := x.MarshalText()
+			if err != nil {
+				return railing.Values{}, err
+			}
+			m.Add("codes2[]", string(text))
+		}
+	}
+	if v.Count != nil {
+		m.Set("count", strconv.FormatUint(uint64(*v.Count), 10))
+	}
+	if len(v.Gifts) > 0 {
+		elems := make([]railing.Values, len(v.Gifts))
+		for i := range v.Gifts {
+			if v.Gifts[i] == nil {
+				continue
+			}
+			elem, err := v.Gifts[i].MarshalQuery()
+			if err != nil {
+				return railing.Values{}, &railing.MarshalerError{Type: reflect.TypeOf(Line{}), Err: err}
+			}
+			elems[i] = elem
+		}
+		m.SetElements("gifts", elems)
+	}
+	m.Set("id", strconv.FormatInt(int64(v.ID), 10))
+	if len(v.Items) > 0 {
+		for _, x := range v.Items {
+			m.Add("items[]", strconv.FormatInt(int64(x), 10))
+		}
+	}
+	if v.Level != 0 {
+		text, err := v.Level.MarshalText()
+		if err != nil {
+			return railing.Values{}, err
+		}
+		m.Set("level", string(text))
+	}
+	if len(v.Levels) > 0 {
+		strs := make([]string, len(v.Levels))
+		for i, x := range v.Levels {
+			text, err := x.MarshalText()
+			if err != nil {
+				return railing.Values{}, err
+			}
+			strs[i] = string(text)
+		}
+		m.Set("levels", strings.Join(strs, ","))
+	}
+	if len(v.Lines) > 0 {
+		elems := make([]railing.Values, len(v.Lines))
+		for i := range v.Lines {
+			elem, err := v.Lines[i].MarshalQuery()
+			if err != nil {
+				return railing.Values{}, &railing.MarshalerError{Type: reflect.TypeOf(Line{}), Err: err}
+			}
+			elems[i] = elem
+		}
+		m.SetElements("lines", elems)
+	}
+	if v.Name != "" {
+		m.Set("name", v.Name)
+	}
+	if v.Paid {
+		m.Set("paid", strconv.FormatBool(bool(v.Paid)))
+	}
+	if v.Price != 0 {
+		m.Set("price", strconv.FormatFloat(float64(v.Price), 'f', -1, 64))
+	}
+	if v.Prio != nil {
+		text, err := v.Prio.MarshalText()
+		if err != nil {
+			return railing.Values{}, err
+		}
+		m.Set("prio", string(text))
+	}
+	m.Set("rate", strconv.FormatFloat(float64(v.Rate), 'f', -1, 64))
+	m.Set("status", string(v.Status))
+	if len(v.Tags) > 0 {
+		strs := make([]string, len(v.Tags))
+		for i, x := range v.Tags {
+			strs[i] = x
+		}
+		m.Set("tags", strings.Join(strs, ","))
+	}
+	return m, nil
+}
+
+// UnmarshalQuery implements railing.Unmarshaler.
+func (v *Order) UnmarshalQuery(m railing.Values) error {
+	var (
+		vals []string
+		ok   bool
+	)
+	if vals, ok = m.Values["Weight"]; !ok {
+		vals = m.Values["Weight[]"]
+	}
+	if len(vals) > 0 {
+		s := vals[len(vals)-1]
+		n, err := strconv.ParseUint(s, 10, 8)
+		if err != nil {
+			return &railing.UnmarshalTypeError{Value: "number " + s, Type: reflect.TypeOf(v.Weight)}
+		}
+		v.Weight = uint8(n)
+	}
+	if obj := m.Object("carrier"); obj.Values != nil {
+		if err := railing.Unmarshal(obj, &v.Carrier); err != nil {
+			return err
+		}
+	}
+	if vals, ok = m.Values["codes2"]; !ok {
+		vals = m.Values["codes2[]"]
+	}
+	if vals != nil {
+		slice := make([]Code, len(vals))
+		for i, s := range vals {
+			if err := slice[i].UnmarshalText([]byte(s)); err != nil {
+				return err
+			}
+		}
+		v.Codes2 = slice
+	} else if elems, err := m.Elements("codes2"); err != nil {
+		if e, ok := err.(*railing.UnmarshalTypeError); ok {
+			return &railing.UnmarshalTypeError{Value: e.Value, Type: reflect.TypeOf(v.Codes2)}
+		}
+		return err
+	} else if elems != nil {
+		slice := make([]Code, len(elems))
+		for i, elem := range elems {
+			vals, ok := elem.Values[""]
+			if !ok || len(elem.Values) != 1 {
+				return &railing.UnmarshalTypeError{Value: "object", Type: reflect.TypeOf(slice[i])}
+			}
+			if len(vals) > 0 {
+				s := vals[len(vals)-1]
+				if err := slice[i].UnmarshalText([]byte(s)); err != nil {
+					return err
+				}
+			}
+		}
+		v.Codes2 = slice
+	}
+	if vals, ok = m.Values["code"]; !ok {
+		vals = m.Values["code[]"]
+	}
+	if len(vals) > 0 {
+		s := vals[len(vals)-1]
+		if s != "" {
+			if err := v.Code.UnmarshalText([]byte(s)); err != nil {
+				return err
+			}
+		}
+	}
+	if vals, ok = m.Values["prio"]; !ok {
+		vals = m.Values["prio[]"]
+	}
+	if vals != nil {
+		if v.Prio == nil {
+			v.Prio = new(Level)
+		}
+		if len(vals) > 0 {
+			s := vals[len(vals)-1]
+			if err := v.Prio.UnmarshalText([]byte(s)); err != nil {
+				return err
+			}
+		}
+	}
+	if vals, ok = m.Values["levels"]; !ok {
+		vals = m.Values["levels[]"]
+	}
+	if vals != nil {
+		var split []string
+		for _, s := range vals {
+			split = append(split, strings.Split(s, ",")...)
+		}
+		vals = split
+		slice := make([]Level, len(vals))
+		for i, s := range vals {
+			if err := slice[i].UnmarshalText([]byte(s)); err != nil {
+				return err
+			}
+		}
+		v.Levels = slice
+	} else if elems, err := m.Elements("levels"); err != nil {
+		if e, ok := err.(*railing.UnmarshalTypeError); ok {
+			return &railing.UnmarshalTypeError{Value: e.Value, Type: reflect.TypeOf(v.Levels)}
+		}
+		return err
+	} else if elems != nil {
+		slice := make([]Level, len(elems))
+		for i, elem := range elems {
+			vals, ok := elem.Values[""]
+			if !ok || len(elem.Values) != 1 {
+				return &railing.UnmarshalTypeError{Value: "object", Type: reflect.TypeOf(slice[i])}
+			}
+			if len(vals) > 0 {
+				s := vals[len(vals)-1]
+				if err := slice[i].UnmarshalText([]byte(s)); err != nil {
+					return err
+				}
+			}
+		}
+		v.Levels = slice
+	}
+	if vals, ok = m.Values["level"]; !ok {
+		vals = m.Values["level[]"]
+	}
+	if len(vals) > 0 {
+		s := vals[len(vals)-1]
+		if s != "" {
+			if err := v.Level.UnmarshalText([]byte(s)); err != nil {
+				return err
+			}
+		}
+	}
+	if elems, err := m.Elements("gifts"); err != nil {
+		if e, ok := err.(*railing.UnmarshalTypeError); ok {
+			return &railing.UnmarshalTypeError{Value: e.Value, Type: reflect.TypeOf(v.Gifts)}
+		}
+		return fmt.Errorf("%s. every slice element must contain the same amount of data", &railing.UnmarshalTypeError{Value: "object", Type: reflect.TypeOf(v.Gifts)})
+	} else if elems != nil {
+		slice := make([]*Line, len(elems))
+		for i, elem := range elems {
+			slice[i] = new(Line)
+			if err := slice[i].UnmarshalQuery(elem); err != nil {
+				return err
+			}
+		}
+		v.Gifts = slice
+	}
+	if elems, err := m.Elements("lines"); err != nil {
+		if e, ok := err.(*railing.UnmarshalTypeError); ok {
+			return &railing.UnmarshalTypeError{Value: e.Value, Type: reflect.TypeOf(v.Lines)}
+		}
+		return fmt.Errorf("%s. every slice element must contain the same amount of data", &railing.UnmarshalTypeError{Value: "object", Type: reflect.TypeOf(v.Lines)})
+	} else if elems != nil {
+		slice := make([]Line, len(elems))
+		for i, elem := range elems {
+			if err := slice[i].UnmarshalQuery(elem); err != nil {
+				return err
+			}
+		}
+		v.Lines = slice
+	}
+	if obj := m.Object("billing"); obj.Values != nil {
+		if v.Billing == nil {
+			v.Billing = new(Address)
+		}
+		if err := v.Billing.UnmarshalQuery(obj); err != nil {
+			return err
+		}
+	}
+	if obj := m.Object("address"); obj.Values != nil {
+		if err := v.Address.UnmarshalQuery(obj); err != nil {
+			return err
+		}
+	}
+	if vals, ok = m.Values["codes"]; !ok {
+		vals = m.Values["codes[]"]
+	}
+	if vals != nil {
+		slice := make([]Status, len(vals))
+		for i, s := range vals {
+			slice[i] = Status(s)
+		}
+		v.Codes = slice
+	} else if elems, err := m.Elements("codes"); err != nil {
+		if e, ok := err.(*railing.UnmarshalTypeError); ok {
+			return &railing.UnmarshalTypeError{Value: e.Value, Type: reflect.TypeOf(v.Codes)}
+		}
+		return err
+	} else if elems != nil {
+		slice := make([]Status, len(elems))
+		for i, elem := range elems {
+			vals, ok := elem.Values[""]
+			if !ok || len(elem.Values) != 1 {
+				return &railing.UnmarshalTypeError{Value: "object", Type: reflect.TypeOf(slice[i])}
+			}
+			if len(vals) > 0 {
+				s := vals[len(vals)-1]
+				slice[i] = Status(s)
+			}
+		}
+		v.Codes = slice
+	}
+	if vals, ok = m.Values["items"]; !ok {
+		vals = m.Values["items[]"]
+	}
+	if vals != nil {
+		slice := make([]int, len(vals))
+		for i, s := range vals {
+			n, err := strconv.ParseInt(s, 10, 0)
+			if err != nil {
+				return &railing.UnmarshalTypeError{Value: "number " + s, Type: reflect.TypeOf(slice[i])}
+			}
+			slice[i] = int(n)
+		}
+		v.Items = slice
+	} else if elems, err := m.Elements("items"); err != nil {
+		if e, ok := err.(*railing.UnmarshalTypeError); ok {
+			return &railing.UnmarshalTypeError{Value: e.Value, Type: reflect.TypeOf(v.Items)}
+		}
+		return err
+	} else if elems != nil {
+		slice := make([]int, len(elems))
+		for i, elem := range elems {
+			vals, ok := elem.Values[""]
+			if !ok || len(elem.Values) != 1 {
+				return &railing.UnmarshalTypeError{Value: "object", Type: reflect.TypeOf(slice[i])}
+			}
+			if len(vals) > 0 {
+				s := vals[len(vals)-1]
+				n, err := strconv.ParseInt(s, 10, 0)
+				if err != nil {
+					return &railing.UnmarshalTypeError{Value: "number " + s, Type: reflect.TypeOf(slice[i])}
+				}
+				slice[i] = int(n)
+			}
+		}
+		v.Items = slice
+	}
+	if vals, ok = m.Values["tags"]; !ok {
+		vals = m.Values["tags[]"]
+	}
+	if vals != nil {
+		var split []string
+		for _, s := range vals {
+			split = append(split, strings.Split(s, ",")...)
+		}
+		vals = split
+		slice := make([]string, len(vals))
+		for i, s := range vals {
+			slice[i] = s
+		}
+		v.Tags = slice
+	} else if elems, err := m.Elements("tags"); err != nil {
+		if e, ok := err.(*railing.UnmarshalTypeError); ok {
+			return &railing.UnmarshalTypeError{Value: e.Value, Type: reflect.TypeOf(v.Tags)}
+		}
+		return err
+	} else if elems != nil {
+		slice := make([]string, len(elems))
+		for i, elem := range elems {
+			vals, ok := elem.Values[""]
+			if !ok || len(elem.Values) != 1 {
+				return &railing.UnmarshalTypeError{Value: "object", Type: reflect.TypeOf(slice[i])}
+			}
+			if len(vals) > 0 {
+				s := vals[len(vals)-1]
+				slice[i] = s
+			}
+		}
+		v.Tags = slice
+	}
+	if vals, ok = m.Values["count"]; !ok {
+		vals = m.Values["count[]"]
+	}
+	if vals != nil {
+		if v.Count == nil {
+			v.Count = new(uint16)
+		}
+		if len(vals) > 0 {
+			s := vals[len(vals)-1]
+			n, err := strconv.ParseUint(s, 10, 16)
+			if err != nil {
+				return &railing.UnmarshalTypeError{Value: "number " + s, Type: reflect.TypeOf(*v.Count)}
+			}
+			*v.Count = uint16(n)
+		}
+	}
+	if vals, ok = m.Values["paid"]; !ok {
+		vals = m.Values["paid[]"]
+	}
+	if len(vals) > 0 {
+		s := vals[len(vals)-1]
+		if s != "" {
+			n, err := strconv.ParseBool(s)
+			if err != nil {
+				return &railing.UnmarshalTypeError{Value: "bool " + s, Type: reflect.TypeOf(v.Paid)}
+			}
+			v.Paid = bool(n)
+		}
+	}
+	if vals, ok = m.Values["rate"]; !ok {
+		vals = m.Values["rate[]"]
+	}
+	if len(vals) > 0 {
+		s := vals[len(vals)-1]
+		n, err := strconv.ParseFloat(s, 32)
+		if err != nil {
+			return &railing.UnmarshalTypeError{Value: "number " + s, Type: reflect.TypeOf(v.Rate)}
+		}
+		v.Rate = float32(n)
+	}
+	if vals, ok = m.Values["price"]; !ok {
+		vals = m.Values["price[]"]
+	}
+	if len(vals) > 0 {
+		s := vals[len(vals)-1]
+		if s != "" {
+			n, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return &railing.UnmarshalTypeError{Value: "number " + s, Type: reflect.TypeOf(v.Price)}
+			}
+			v.Price = float64(n)
+		}
+	}
+	if vals, ok = m.Values["status"]; !ok {
+		vals = m.Values["status[]"]
+	}
+	if len(vals) > 0 {
+		s := vals[len(vals)-1]
+		v.Status = Status(s)
+	}
+	if vals, ok = m.Values["name"]; !ok {
+		vals = m.Values["name[]"]
+	}
+	if len(vals) > 0 {
+		s := vals[len(vals)-1]
+		v.Name = s
+	}
+	if vals, ok = m.Values["id"]; !ok {
+		vals = m.Values["id[]"]
+	}
+	if len(vals) > 0 {
+		s := vals[len(vals)-1]
+		n, err := strconv.ParseInt(s, 10, 0)
+		if err != nil {
+			return &railing.UnmarshalTypeError{Value: "number " + s, Type: reflect.TypeOf(v.ID)}
+		}
+		v.ID = int(n)
+	}
+	return nil
+}
+
+// MarshalQuery implements railing.Marshaler.
+func (v Address) MarshalQuery() (railing.Values, error) {
+	m := railing.NewValues()
+	m.Set("city", v.City)
+	if v.Zip != "" {
+		m.Set("zip", v.Zip)
+	}
+	return m, nil
+}
+
+// UnmarshalQuery implements railing.Unmarshaler.
+func (v *Address) UnmarshalQuery(m railing.Values) error {
+	var (
+		vals []string
+		ok   bool
+	)
+	if vals, ok = m.Values["zip"]; !ok {
+		vals = m.Values["zip[]"]
+	}
+	if len(vals) > 0 {
+		s := vals[len(vals)-1]
+		v.Zip = s
+	}
+	if vals, ok = m.Values["city"]; !ok {
+		vals = m.Values["city[]"]
+	}
+	if len(vals) > 0 {
+		s := vals[len(vals)-1]
+		v.City = s
+	}
+	return nil
+}
+
+// MarshalQuery implements railing.Marshaler.
+func (v Line) MarshalQuery() (railing.Values, error) {
+	m := railing.NewValues()
+	m.Set("qty", strconv.FormatInt(int64(v.Qty), 10))
+	m.Set("sku", v.SKU)
+	return m, nil
+}
+
+// UnmarshalQuery implements railing.Unmarshaler.
+func (v *Line) UnmarshalQuery(m railing.Values) error {
+	var (
+		vals []string
+		ok   bool
+	)
+	if vals, ok = m.Values["qty"]; !ok {
+		vals = m.Values["qty[]"]
+	}
+	if len(vals) > 0 {
+		s := vals[len(vals)-1]
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return &railing.UnmarshalTypeError{Value: "number " + s, Type: reflect.TypeOf(v.Qty)}
+		}
+		v.Qty = int64(n)
+	}
+	if vals, ok = m.Values["sku"]; !ok {
+		vals = m.Values["sku[]"]
+	}
+	if len(vals) > 0 {
+		s := vals[len(vals)-1]
+		v.SKU = s
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+// Package gentest holds the types which test the methods generated by
+// railinggen against railing.Marshal and railing.Unmarshal.
+package gentest
+
+//go:generate go run github.com/jszwec/railing/cmd/railinggen -type=Order,Address,Line
+
+import (
+	"errors"
+	"strings"
+)
+
+// Status is a named simple type.
+type Status string
+
+// Level is a simple type encoded as text.
+type Level int
+
+// MarshalText implements encoding.TextMarshaler.
+func (l Level) MarshalText() ([]byte, error) {
+	switch l {
+	case 0:
+		return []byte("low"), nil
+	case 1:
+		return []byte("high"), nil
+	}
+	return nil, errors.New("unknown level")
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (l *Level) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "low":
+		*l = 0
+	case "high":
+		*l = 1
+	default:
+		return errors.New("unknown level " + string(text))
+	}
+	return nil
+}
+
+// Code is a struct encoded as text.
+type Code struct {
+	Prefix, Number string
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (c *Code) MarshalText() ([]byte, error) {
+	return []byte(c.Prefix + "-" + c.Number), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (c *Code) UnmarshalText(text []byte) error {
+	i := strings.IndexByte(string(text), '-')
+	if i < 0 {
+		return errors.New("invalid code " + string(text))
+	}
+	c.Prefix, c.Number = string(text[:i]), string(text[i+1:])
+	return nil
+}
+
+// Order uses every kind of field supported by railinggen.
+type Order struct {
+	ID      int      `railing:"id"`
+	Name    string   `railing:"name,omitempty"`
+	Status  Status   `railing:"status"`
+	Price   float64  `railing:"price,omitempty"`
+	Rate    float32  `railing:"rate"`
+	Paid    bool     `railing:"paid,omitempty"`
+	Count   *uint16  `railing:"count"`
+	Tags    []string `railing:"tags,comma"`
+	Items   []int    `railing:"items"`
+	Codes   []Status `railing:"codes,omitempty"`
+	Address Address  `railing:"address"`
+	Billing *Address `railing:"billing"`
+	Lines   []Line   `railing:"lines"`
+	Gifts   []*Line  `railing:"gifts"`
+	Level   Level    `railing:"level,omitempty"`
+	Levels  []Level  `railing:"levels,comma"`
+	Prio    *Level   `railing:"prio"`
+	Code    Code     `railing:"code,omitempty"`
+	Codes2  []Code   `railing:"codes2"`
+	Carrier *Carrier `railing:"carrier"`
+	Note    string   `railing:"-"`
+	Weight  uint8
+	secret  string
+}
+
+// Address is a nested struct.
+type Address struct {
+	City string `railing:"city"`
+	Zip  string `railing:"zip,omitempty"`
+}
+
+// Line is an element of a slice of structs.
+type Line struct {
+	SKU string `railing:"sku"`
+	Qty int64  `railing:"qty"`
+}
+
+// Carrier is a nested struct which is not generated, so it is encoded and
+// decoded by reflection.
+type Carrier struct {
+	Name string `railing:"name"`
+}
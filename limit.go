@@ -0,0 +1,128 @@
+package railing
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Limit is one of the limits of DecoderOptions.
+type Limit int
+
+// The limits of DecoderOptions.
+const (
+	// DepthLimit is the limit of the nesting of a key - MaxDepth.
+	DepthLimit Limit = iota + 1
+
+	// KeyCountLimit is the limit of the number of params - MaxKeys.
+	KeyCountLimit
+
+	// ArrayLengthLimit is the limit of the number of elements of an array -
+	// MaxArrayLength.
+	ArrayLengthLimit
+
+	// ValueSizeLimit is the limit of the size of a value - MaxValueSize.
+	ValueSizeLimit
+)
+
+func (l Limit) String() string {
+	switch l {
+	case DepthLimit:
+		return "depth"
+	case KeyCountLimit:
+		return "key count"
+	case ArrayLengthLimit:
+		return "array length"
+	case ValueSizeLimit:
+		return "value size"
+	default:
+		return "unknown"
+	}
+}
+
+// A LimitError is returned by ParseQuery, Unmarshal and Decoder's Decode when
+// the query string or the Values exceed one of the limits of DecoderOptions.
+// Key is the key of the param which exceeded the limit, or the name of the
+// field if the limit is exceeded while the field is decoded.
+type LimitError struct {
+	Limit Limit
+	Key   string
+	Max   int
+}
+
+func (e *LimitError) Error() string {
+	return "railing: param " + e.Key + " exceeds the " + e.Limit.String() +
+		" limit of " + strconv.Itoa(e.Max)
+}
+
+// limited reports whether any of the limits is set.
+func (o DecoderOptions) limited() bool {
+	return o.MaxDepth > 0 || o.MaxKeys > 0 || o.MaxArrayLength > 0 ||
+		o.MaxValueSize > 0
+}
+
+// checkParam returns LimitError if the n-th param, counting from 1, exceeds
+// the limits of its depth, the number of params or the size of its value.
+func (o DecoderOptions) checkParam(key, value string, n int) error {
+	switch {
+	case o.MaxKeys > 0 && n > o.MaxKeys:
+		return &LimitError{KeyCountLimit, key, o.MaxKeys}
+	case o.MaxDepth > 0 && keyDepth(key) > o.MaxDepth:
+		return &LimitError{DepthLimit, key, o.MaxDepth}
+	case o.MaxValueSize > 0 && len(value) > o.MaxValueSize:
+		return &LimitError{ValueSizeLimit, key, o.MaxValueSize}
+	}
+	return nil
+}
+
+// checkValues checks every pair of m the same way checkParam checks the params
+// of the query string. The lengths of arrays are checked while they are
+// decoded.
+func (o DecoderOptions) checkValues(m OrderedValues) error {
+	if !o.limited() {
+		return nil
+	}
+	n := 0
+	for k, vals := range m.Values {
+		if len(vals) == 0 {
+			vals = []string{""}
+		}
+		for _, v := range vals {
+			n++
+			if err := o.checkParam(k, v, n); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// keySpaceLimit is the size of the escaped key of a param, which is allowed
+// besides MaxValueSize while the param is read. It is the same as rack's
+// default key space limit.
+const keySpaceLimit = 64 << 10
+
+// paramSize returns the size of the longest param, together with '=' and the
+// following '&', which can hold a value of MaxValueSize, or zero if the value
+// size is not limited. Every byte of the value may be escaped as three bytes,
+// eg. "%41".
+func (o DecoderOptions) paramSize() int {
+	if o.MaxValueSize <= 0 {
+		return 0
+	}
+	return keySpaceLimit + 2 + 3*o.MaxValueSize
+}
+
+// keyDepth returns the nesting of the key - the number of its brackets, eg. 2
+// for "a[b][]".
+func keyDepth(key string) int {
+	return strings.Count(key, "[")
+}
+
+// checkLength returns LimitError if the array of n elements under the key is
+// longer than MaxArrayLength.
+func (d *decoder) checkLength(key string, n int) error {
+	if d.maxArrayLength > 0 && n > d.maxArrayLength {
+		return &LimitError{ArrayLengthLimit, key, d.maxArrayLength}
+	}
+	return nil
+}
@@ -0,0 +1,295 @@
+package railing
+
+import "strings"
+
+// NodeKind is the kind of a Node.
+type NodeKind int
+
+// The kinds of nodes, they correspond to the types which rails uses for params.
+const (
+	StringNode NodeKind = iota
+	HashNode
+	ArrayNode
+)
+
+func (k NodeKind) String() string {
+	switch k {
+	case HashNode:
+		return "object"
+	case ArrayNode:
+		return "array"
+	default:
+		return "string"
+	}
+}
+
+// Node is a single param in the tree of params. It is either a string, a hash
+// (an object) or an array - the same way rails represents params. The root of
+// the tree is always a hash.
+//
+//	// user[name]=bob&user[tags][]=a&user[tags][]=b
+//	Hash{
+//	  "user": Hash{
+//	    "name": String("bob"),
+//	    "tags": Array{String("a"), String("b")},
+//	  },
+//	}
+//
+// Unlike rails, a string node keeps every value which was assigned to its key,
+// the same way url.Values does.
+type Node struct {
+	kind   NodeKind
+	values []string
+	keys   []string
+	hash   map[string]*Node
+	elems  []*Node
+	next   int // one more than the greatest index among the keys of a hash
+}
+
+// NewStringNode returns a string node holding the given values.
+func NewStringNode(values ...string) *Node {
+	return &Node{kind: StringNode, values: values}
+}
+
+// NewHashNode returns an empty hash node.
+func NewHashNode() *Node {
+	return &Node{kind: HashNode, hash: make(map[string]*Node)}
+}
+
+// NewArrayNode returns an array node holding the given elements. Nil elements
+// are skipped.
+func NewArrayNode(elems ...*Node) *Node {
+	n := &Node{kind: ArrayNode}
+	n.Append(elems...)
+	return n
+}
+
+// Kind returns the kind of the node.
+func (n *Node) Kind() NodeKind {
+	return n.kind
+}
+
+// Value returns the value of a string node. If there are many values, the last
+// one is returned, as it is the one which rails keeps. It returns an empty
+// string for other kinds of nodes.
+func (n *Node) Value() string {
+	if len(n.values) == 0 {
+		return ""
+	}
+	return n.values[len(n.values)-1]
+}
+
+// Values returns all the values of a string node.
+func (n *Node) Values() []string {
+	return n.values
+}
+
+// Keys returns the keys of a hash node in the order they were added.
+func (n *Node) Keys() []string {
+	return n.keys
+}
+
+// Get returns the node stored under the key of a hash node or nil if there is
+// no such key.
+func (n *Node) Get(key string) *Node {
+	return n.hash[key]
+}
+
+// Set stores the child under the key of a hash node. A new key is added after
+// the existing ones. It does nothing if n is not a hash or the child is nil.
+func (n *Node) Set(key string, child *Node) {
+	if n.kind != HashNode || child == nil {
+		return
+	}
+	if _, ok := n.hash[key]; !ok {
+		n.keys = append(n.keys, key)
+		if i, ok := parseIndex(key); ok && i >= n.next {
+			n.next = i + 1
+		}
+	}
+	n.hash[key] = child
+}
+
+// parseIndex returns the index which the key of a hash holds, eg. 2 for "2".
+// Only the keys made of decimal digits are indexes.
+func parseIndex(key string) (int, bool) {
+	if key == "" || len(key) > 9 {
+		return 0, false
+	}
+	i := 0
+	for j := 0; j < len(key); j++ {
+		if key[j] < '0' || key[j] > '9' {
+			return 0, false
+		}
+		i = i*10 + int(key[j]-'0')
+	}
+	return i, true
+}
+
+// Del deletes the key from a hash node.
+func (n *Node) Del(key string) {
+	if _, ok := n.hash[key]; !ok {
+		return
+	}
+	delete(n.hash, key)
+	for i, k := range n.keys {
+		if k == key {
+			n.keys = append(n.keys[:i:i], n.keys[i+1:]...)
+			break
+		}
+	}
+}
+
+// Len returns the number of elements of an array node, the number of keys of
+// a hash node or the number of values of a string node.
+func (n *Node) Len() int {
+	switch n.kind {
+	case HashNode:
+		return len(n.keys)
+	case ArrayNode:
+		return len(n.elems)
+	default:
+		return len(n.values)
+	}
+}
+
+// Index returns the i-th element of an array node.
+func (n *Node) Index(i int) *Node {
+	return n.elems[i]
+}
+
+// Append adds the elements to an array node. It does nothing if n is not an
+// array. Nil elements are skipped.
+func (n *Node) Append(elems ...*Node) {
+	if n.kind != ArrayNode {
+		return
+	}
+	for _, elem := range elems {
+		if elem != nil {
+			n.elems = append(n.elems, elem)
+		}
+	}
+}
+
+// stringChild returns the string node stored under the given key. Any other
+// kind of node stored under the key is replaced with a new string node.
+func (n *Node) stringChild(key string) *Node {
+	if child, ok := n.hash[key]; ok && child.kind == StringNode {
+		return child
+	}
+	child := NewStringNode()
+	n.Set(key, child)
+	return child
+}
+
+// child returns the node of the given kind stored under the key creating it
+// if necessary. It returns ParameterTypeError if the key holds a node of
+// a different kind.
+func (n *Node) child(key string, kind NodeKind) (*Node, error) {
+	child, ok := n.hash[key]
+	if !ok {
+		if kind == ArrayNode {
+			child = NewArrayNode()
+		} else {
+			child = NewHashNode()
+		}
+		n.Set(key, child)
+	}
+	if child.kind != kind {
+		return nil, &ParameterTypeError{key, kind.String(), child.kind.String()}
+	}
+	return child, nil
+}
+
+// last returns the last element of an array or nil if it is empty.
+func (n *Node) last() *Node {
+	if len(n.elems) == 0 {
+		return nil
+	}
+	return n.elems[len(n.elems)-1]
+}
+
+// hasKey reports whether the hash contains the nested key eg. "[a][b]". Keys
+// which contain an array never exist, so that they are always added to the
+// last element of an array.
+func (n *Node) hasKey(key string) bool {
+	if strings.Contains(key, "[]") {
+		return false
+	}
+	parts := strings.FieldsFunc(key, func(r rune) bool {
+		return r == '[' || r == ']'
+	})
+	for _, part := range parts {
+		if n.kind != HashNode {
+			return false
+		}
+		child, ok := n.hash[part]
+		if !ok {
+			return false
+		}
+		n = child
+	}
+	return true
+}
+
+// walk calls fn for every string node of the tree with its key in url.Values.
+// Elements of arrays get the "[]" suffix, eg. "foo[][bar]".
+func (n *Node) walk(prefix string, fn func(key string, n *Node)) {
+	switch n.kind {
+	case StringNode:
+		fn(prefix, n)
+	case HashNode:
+		for _, k := range n.keys {
+			key := k
+			if prefix != "" {
+				key = prefix + "[" + k + "]"
+			}
+			n.hash[k].walk(key, fn)
+		}
+	case ArrayNode:
+		for _, elem := range n.elems {
+			elem.walk(prefix+"[]", fn)
+		}
+	}
+}
+
+// Tree returns the values as a tree of params. The pairs are added to the tree
+// in the order they are encoded, following the same rules as ParseQuery does.
+// A ParameterTypeError is returned if a key is used both as an array, a hash
+// or a string.
+func (v Values) Tree() (*Node, error) {
+	return OrderedValues{Values: v.Values}.Tree()
+}
+
+// Tree returns the ordered values as a tree of params the same way Values.Tree
+// does it.
+func (v OrderedValues) Tree() (*Node, error) {
+	p := parser{root: NewHashNode()}
+	for _, pair := range v.Pairs() {
+		if _, err := p.normalize(p.root, pair.Key, pair.Value, 0); err != nil {
+			return nil, err
+		}
+	}
+	return p.root, nil
+}
+
+// FromTree returns OrderedValues holding every string of the tree. Keys of
+// the hashes are written in their order and arrays of hashes are written
+// element by element, eg. "foo[][id]=1&foo[][name]=a&foo[][id]=2". The root
+// must be a hash.
+func FromTree(root *Node) (OrderedValues, error) {
+	if root == nil {
+		return OrderedValues{}, &ParameterTypeError{"", HashNode.String(), "nil"}
+	}
+	if root.kind != HashNode {
+		return OrderedValues{}, &ParameterTypeError{"", HashNode.String(),
+			root.kind.String()}
+	}
+	v := NewOrderedValues()
+	root.walk("", func(key string, n *Node) {
+		for _, value := range n.values {
+			v.Add(key, value)
+		}
+	})
+	return v, nil
+}
@@ -0,0 +1,166 @@
+package railing
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func hash(kv ...interface{}) *Node {
+	n := NewHashNode()
+	for i := 0; i < len(kv); i += 2 {
+		n.Set(kv[i].(string), kv[i+1].(*Node))
+	}
+	return n
+}
+
+func str(values ...string) *Node {
+	return NewStringNode(values...)
+}
+
+func TestTree(t *testing.T) {
+	fixtures := []struct {
+		in  OrderedValues
+		out *Node
+		err error
+	}{
+		// 0
+		{
+			in:  OrderedValues{Values: url.Values{}},
+			out: hash(),
+		},
+		// 1
+		{
+			in: OrderedValues{Values: url.Values{
+				"b":            []string{"1", "2"},
+				"a[]":          []string{"1", "2"},
+				"c[][id]":      []string{"1", "2"},
+				"c[][name]":    []string{"x"},
+				"d[e][f]":      []string{"3"},
+				"d[e][g][][h]": []string{"4"},
+			}},
+			out: hash(
+				"a", NewArrayNode(str("1"), str("2")),
+				"b", str("1", "2"),
+				"c", NewArrayNode(
+					hash("id", str("1"), "name", str("x")),
+					hash("id", str("2")),
+				),
+				"d", hash("e", hash(
+					"f", str("3"),
+					"g", NewArrayNode(hash("h", str("4"))),
+				)),
+			),
+		},
+		// 2
+		{
+			in: NewOrderedValues(
+				Pair{"objs[][id]", "1"},
+				Pair{"objs[][name]", "a"},
+				Pair{"objs[][id]", "2"},
+				Pair{"objs[][tags][]", "x"},
+				Pair{"objs[][tags][]", "y"},
+			),
+			out: hash(
+				"objs", NewArrayNode(
+					hash("id", str("1"), "name", str("a")),
+					hash("id", str("2"), "tags", NewArrayNode(str("x"), str("y"))),
+				),
+			),
+		},
+		// 3
+		{
+			in:  NewOrderedValues(Pair{"a[]", "1"}, Pair{"a[b]", "2"}),
+			err: &ParameterTypeError{"a", "object", "array"},
+		},
+	}
+	for i, fixture := range fixtures {
+		out, err := fixture.in.Tree()
+		if !reflect.DeepEqual(fixture.err, err) {
+			t.Errorf("expected err=%v; got %v (i=%d)", fixture.err, err, i)
+			continue
+		}
+		if !reflect.DeepEqual(out, fixture.out) {
+			t.Errorf("expected %#v; got %#v (i=%d)", fixture.out, out, i)
+		}
+	}
+}
+
+func TestFromTree(t *testing.T) {
+	root := hash(
+		"z", str("1"),
+		"objs", NewArrayNode(
+			hash("id", str("1"), "tags", NewArrayNode(str("x"), str("y"))),
+			hash("id", str("2")),
+		),
+	)
+	root.Get("objs").Append(hash("id", str("3")))
+	root.Set("a", hash("b", str("c")))
+	root.Del("z")
+	v, err := FromTree(root)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %v", err)
+	}
+	expected := []Pair{
+		{"objs[][id]", "1"},
+		{"objs[][tags][]", "x"},
+		{"objs[][tags][]", "y"},
+		{"objs[][id]", "2"},
+		{"objs[][id]", "3"},
+		{"a[b]", "c"},
+	}
+	if pairs := v.Pairs(); !reflect.DeepEqual(pairs, expected) {
+		t.Errorf("expected %v; got %v", expected, pairs)
+	}
+	tree, err := v.Tree()
+	if err != nil {
+		t.Fatalf("expected err=nil; got %v", err)
+	}
+	if !reflect.DeepEqual(tree, root) {
+		t.Errorf("expected %#v; got %#v", root, tree)
+	}
+	expectedErr := &ParameterTypeError{"", "object", "array"}
+	if _, err := FromTree(NewArrayNode()); !reflect.DeepEqual(err,
+		expectedErr) {
+		t.Errorf("expected err=%v; got %v", expectedErr, err)
+	}
+	expectedErr = &ParameterTypeError{"", "object", "nil"}
+	if _, err := FromTree(nil); !reflect.DeepEqual(err, expectedErr) {
+		t.Errorf("expected err=%v; got %v", expectedErr, err)
+	}
+}
+
+func TestNodeKindMismatch(t *testing.T) {
+	fixtures := []struct {
+		n   *Node
+		out *Node
+	}{
+		// 0
+		{n: str("a"), out: str("a")},
+		// 1
+		{n: NewArrayNode(str("a")), out: NewArrayNode(str("a"))},
+		// 2
+		{n: hash("a", str("b")), out: hash("a", str("b"), "c", str("d"))},
+	}
+	for i, fixture := range fixtures {
+		fixture.n.Set("c", str("d"))
+		fixture.n.Set("e", nil)
+		if !reflect.DeepEqual(fixture.n, fixture.out) {
+			t.Errorf("expected %#v; got %#v (i=%d)", fixture.out, fixture.n, i)
+		}
+	}
+
+	n := hash()
+	n.Append(str("a"))
+	if !reflect.DeepEqual(n, hash()) {
+		t.Errorf("expected %#v; got %#v", hash(), n)
+	}
+	arr := NewArrayNode(nil, str("a"))
+	arr.Append(nil)
+	if !reflect.DeepEqual(arr, NewArrayNode(str("a"))) {
+		t.Errorf("expected %#v; got %#v", NewArrayNode(str("a")), arr)
+	}
+	if _, err := FromTree(hash("a", arr)); err != nil {
+		t.Errorf("expected err=nil; got %v", err)
+	}
+}
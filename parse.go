@@ -0,0 +1,329 @@
+package railing
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+)
+
+// A ParameterTypeError is returned by ParseQuery when the same key is used
+// for different kinds of values, eg. "user=bob&user[name]=bob".
+type ParameterTypeError struct {
+	Key      string
+	Expected string
+	Got      string
+}
+
+func (e *ParameterTypeError) Error() string {
+	return "railing: expected " + e.Expected + " (got " + e.Got +
+		") for param " + e.Key
+}
+
+// An InvalidParameterError is returned by ParseQuery when a key or a value
+// of the query string is not properly escaped.
+type InvalidParameterError struct {
+	Param string
+	Err   error
+}
+
+func (e *InvalidParameterError) Error() string {
+	return "railing: invalid parameter " + e.Param + ": " + e.Err.Error()
+}
+
+// ParseQuery parses the URL-encoded query string and returns Values which can
+// be passed directly to Unmarshal.
+//
+// ParseQuery follows the rules of rack's parse_nested_query, so that the query
+// string is understood the same way rails understands it:
+//   - pairs are separated by '&' and a key without '=' has an empty value, or
+//     it is nil with the BareNils option of DecoderOptions,
+//   - "foo[bar]" is a key "bar" of the object "foo",
+//   - "foo[]" appends a value to the array "foo",
+//   - "foo[][bar]" sets the key "bar" of the last object of the array "foo",
+//     unless that object already has the key "bar" - then a new object is
+//     appended to the array.
+//
+// The keys of the returned Values are normalized, eg. "foo[bar]baz" becomes
+// "foo[bar][baz]", and the pairs which were overwritten by the following ones
+// are dropped. A ParameterTypeError is returned if a key is used both as an
+// array, an object or a string.
+//
+// The returned Values do not keep the order of the pairs, so the elements of
+// an array of objects which have different keys cannot be told apart by
+// Unmarshal. ParseQueryOrdered keeps the order.
+func ParseQuery(query string) (Values, error) {
+	return DecoderOptions{}.ParseQuery(query)
+}
+
+// ParseQueryOrdered parses the query string the same way ParseQuery does it,
+// but it returns OrderedValues holding the pairs in the order they were parsed.
+// Encode of the result gives back the same query string, unless some of its
+// params were overwritten, and UnmarshalOrdered divides the arrays of objects
+// into the same elements rack does.
+func ParseQueryOrdered(query string) (OrderedValues, error) {
+	return DecoderOptions{}.ParseQueryOrdered(query)
+}
+
+// ParseQuery parses the query string according to the dialect of the options.
+// The Rails dialect behaves the same way as ParseQuery function. The params are
+// checked against the limits of the options as soon as they are read.
+func (o DecoderOptions) ParseQuery(query string) (Values, error) {
+	m, err := o.parse(strings.NewReader(query))
+	return Values{m.Values}, err
+}
+
+// ParseQueryOrdered parses the query string according to the options the same
+// way ParseQuery does it, but it returns OrderedValues. The Rails dialect keeps
+// the order of the parsed pairs, other dialects list the keys in the order of
+// the tree of params.
+func (o DecoderOptions) ParseQueryOrdered(query string) (OrderedValues, error) {
+	return o.parse(strings.NewReader(query))
+}
+
+// parse reads the query string from r param by param, adding every param to
+// the tree of params as soon as it is read.
+func (o DecoderOptions) parse(r io.Reader) (OrderedValues, error) {
+	d := o.dialect()
+	ordered, keepsOrder := d.(orderedNormalizer)
+	limited, keepsLimits := d.(limitedNormalizer)
+	p := parser{root: NewHashNode(), maxArrayLength: o.MaxArrayLength}
+	br, max := bufio.NewReader(r), o.paramSize()
+	for n := 0; ; {
+		param, err := readParam(br, max)
+		if err == errParamSize {
+			key := param
+			if i := strings.IndexByte(param, '='); i >= 0 {
+				key = param[:i]
+			}
+			if k, err := d.Unescape(key); err == nil {
+				key = k
+			}
+			return OrderedValues{}, &LimitError{ValueSizeLimit, key, o.MaxValueSize}
+		}
+		if err != nil && err != io.EOF {
+			return OrderedValues{}, err
+		}
+		eof := err == io.EOF
+		param = strings.TrimLeft(strings.TrimSuffix(param, "&"), " ")
+		if param != "" {
+			n++
+			key, value := param, ""
+			p.isNil = o.BareNils
+			if i := strings.IndexByte(param, '='); i >= 0 {
+				key, value, p.isNil = param[:i], param[i+1:], false
+			}
+			if key, err = d.Unescape(key); err != nil {
+				return OrderedValues{}, &InvalidParameterError{param, err}
+			}
+			if value, err = d.Unescape(value); err != nil {
+				return OrderedValues{}, &InvalidParameterError{param, err}
+			}
+			if err := o.checkParam(key, value, n); err != nil {
+				return OrderedValues{}, err
+			}
+			p.key = key
+			switch {
+			case keepsOrder:
+				err = ordered.normalizeOrdered(&p, key, value)
+			case keepsLimits:
+				err = limited.normalizeLimited(&p, key, value)
+			default:
+				err = d.Normalize(p.root, key, value)
+			}
+			if err != nil {
+				return OrderedValues{}, err
+			}
+		}
+		if eof {
+			break
+		}
+	}
+	if keepsOrder {
+		return p.values(), nil
+	}
+	return FromTree(p.root)
+}
+
+// errParamSize is returned by readParam when the param is too long.
+var errParamSize = errors.New("railing: param too long")
+
+// readParam reads the param up to and including the next '&'. If max is not
+// zero and the param is longer than max bytes, it stops reading as soon as
+// the limit is passed and returns the part of the param read so far together
+// with errParamSize.
+func readParam(br *bufio.Reader, max int) (string, error) {
+	var buf []byte
+	for {
+		frag, err := br.ReadSlice('&')
+		if max > 0 && len(buf)+len(frag) > max {
+			return string(append(buf, frag[:max-len(buf)]...)), errParamSize
+		}
+		if err != bufio.ErrBufferFull {
+			if buf == nil {
+				return string(frag), err
+			}
+			return string(append(buf, frag...)), err
+		}
+		buf = append(buf, frag...)
+	}
+}
+
+// orderedNormalizer is implemented by dialects which build Values keeping the
+// order of the parsed pairs.
+type orderedNormalizer interface {
+	normalizeOrdered(p *parser, key, value string) error
+}
+
+// limitedNormalizer is implemented by dialects which limit the length of the
+// arrays of the parser.
+type limitedNormalizer interface {
+	normalizeLimited(p *parser, key, value string) error
+}
+
+// parser builds the tree of params. It remembers which string node received
+// every parsed value, so that the values can be listed in the original order.
+// isNil is true while the param without '=' is parsed as nil, and key is the
+// key of the param being parsed. The arrays cannot be longer than
+// maxArrayLength, unless it is zero.
+type parser struct {
+	root           *Node
+	leaves         []leaf
+	isNil          bool
+	key            string
+	maxArrayLength int
+}
+
+type leaf struct {
+	node  *Node
+	value string
+	isNil bool
+}
+
+// values returns the parsed params as OrderedValues. The values which are no
+// longer part of the tree are skipped. A nil value is a nil key of Values,
+// unless its key has other values or it is a part of an array, eg. "foo[]" or
+// "foo[][bar]", where it is an empty value.
+func (p *parser) values() OrderedValues {
+	keys := make(map[*Node]string)
+	p.root.walk("", func(key string, n *Node) {
+		keys[n] = key
+	})
+	v := NewOrderedValues()
+	for _, l := range p.leaves {
+		key, ok := keys[l.node]
+		switch {
+		case !ok:
+		case l.isNil && len(l.node.values) == 1 && !strings.Contains(key, "[]"):
+			v.SetNil(key)
+		default:
+			v.Add(key, l.value)
+		}
+	}
+	return v
+}
+
+// splitKey returns the first segment of the name and the rest of it. At the
+// top level the segment is everything before the first bracket, deeper it is
+// either "[]" or the content of the first pair of brackets.
+//
+//   - foo[bar][] (depth 0) -> foo, [bar][]
+//   - [bar][]    (depth 1) -> bar, []
+//   - [][bar]    (depth 1) -> [], [bar]
+func splitKey(name string, depth int) (k, after string) {
+	switch {
+	case depth == 0:
+		if len(name) > 1 {
+			if i := strings.IndexByte(name[1:], '['); i >= 0 {
+				return name[:i+1], name[i+1:]
+			}
+		}
+	case strings.HasPrefix(name, "[]"):
+		return "[]", name[2:]
+	case strings.HasPrefix(name, "["):
+		if i := strings.IndexByte(name[1:], ']'); i >= 0 {
+			return name[1 : i+1], name[i+2:]
+		}
+	}
+	return name, ""
+}
+
+// normalize stores the value under the given name in the object n. It is
+// a port of rack's normalize_params. It returns the node which the caller
+// should store instead of n - normally it is n itself, but for the "[]" key
+// it is a new array holding the value.
+func (p *parser) normalize(n *Node, name, value string,
+	depth int) (*Node, error) {
+	k, after := splitKey(name, depth)
+	if k == "" {
+		return n, nil
+	}
+	switch {
+	case after == "":
+		if k == "[]" && depth != 0 {
+			return NewArrayNode(p.leaf(NewStringNode(), value)), nil
+		}
+		p.leaf(n.stringChild(k), value)
+	case after == "[":
+		p.leaf(n.stringChild(name), value)
+	case after == "[]":
+		arr, err := n.child(k, ArrayNode)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.checkLength(arr); err != nil {
+			return nil, err
+		}
+		arr.elems = append(arr.elems, p.leaf(NewStringNode(), value))
+	case strings.HasPrefix(after, "[]"):
+		childKey := after[2:]
+		if len(after) > 3 && after[2] == '[' && strings.HasSuffix(after, "]") {
+			if ck := after[3 : len(after)-1]; ck != "" &&
+				!strings.ContainsAny(ck, "[]") {
+				childKey = ck
+			}
+		}
+		arr, err := n.child(k, ArrayNode)
+		if err != nil {
+			return nil, err
+		}
+		if last := arr.last(); last != nil && last.kind == HashNode &&
+			!last.hasKey(childKey) {
+			_, err = p.normalize(last, childKey, value, depth+1)
+			return n, err
+		}
+		if err := p.checkLength(arr); err != nil {
+			return nil, err
+		}
+		elem, err := p.normalize(NewHashNode(), childKey, value, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		arr.elems = append(arr.elems, elem)
+	default:
+		hash, err := n.child(k, HashNode)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.normalize(hash, after, value, depth+1); err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+// checkLength returns LimitError if the array has no room for one more
+// element.
+func (p *parser) checkLength(arr *Node) error {
+	if p.maxArrayLength > 0 && arr.Len() >= p.maxArrayLength {
+		return &LimitError{ArrayLengthLimit, p.key, p.maxArrayLength}
+	}
+	return nil
+}
+
+// leaf appends the value to the string node and records it.
+func (p *parser) leaf(n *Node, value string) *Node {
+	n.values = append(n.values, value)
+	p.leaves = append(p.leaves, leaf{n, value, p.isNil})
+	return n
+}
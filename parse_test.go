@@ -0,0 +1,230 @@
+package railing
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	fixtures := []struct {
+		in  string
+		out url.Values
+		err error
+	}{
+		// 0
+		{
+			in:  "",
+			out: url.Values{},
+		},
+		// 1
+		{
+			in: "foo=1&bar=a+b&baz",
+			out: url.Values{
+				"foo": []string{"1"},
+				"bar": []string{"a b"},
+				"baz": []string{""},
+			},
+		},
+		// 2
+		{
+			in: "foo=1&foo=2&&& bar=3",
+			out: url.Values{
+				"foo": []string{"1", "2"},
+				"bar": []string{"3"},
+			},
+		},
+		// 3
+		{
+			in: "ids[]=1&ids%5B%5D=2&user[name]=bob&user[address][city]=NY",
+			out: url.Values{
+				"ids[]":               []string{"1", "2"},
+				"user[name]":          []string{"bob"},
+				"user[address][city]": []string{"NY"},
+			},
+		},
+		// 4
+		{
+			in: "objs[][id]=1&objs[][name]=a&objs[][id]=2&objs[][name]=b",
+			out: url.Values{
+				"objs[][id]":   []string{"1", "2"},
+				"objs[][name]": []string{"a", "b"},
+			},
+		},
+		// 5
+		{
+			in: "objs[][items][]=1&objs[][items][]=2&objs[][tag][name]=a",
+			out: url.Values{
+				"objs[][items][]":   []string{"1", "2"},
+				"objs[][tag][name]": []string{"a"},
+			},
+		},
+		// 6
+		{
+			in: "foo[bar]baz=1&foo[=2&a[][]=3",
+			out: url.Values{
+				"foo[bar][baz]": []string{"1"},
+				"foo[":          []string{"2"},
+				"a[][]":         []string{"3"},
+			},
+		},
+		// 7
+		{
+			in: "foo[bar]=1&foo=2",
+			out: url.Values{
+				"foo": []string{"2"},
+			},
+		},
+		//
+		// errors
+		//
+		// 8
+		{
+			in:  "foo=1&foo[bar]=2",
+			err: &ParameterTypeError{"foo", "object", "string"},
+		},
+		// 9
+		{
+			in:  "foo[]=1&foo[bar]=2",
+			err: &ParameterTypeError{"foo", "object", "array"},
+		},
+		// 10
+		{
+			in:  "foo[bar]=1&foo[][id]=2",
+			err: &ParameterTypeError{"foo", "array", "object"},
+		},
+		// 11
+		{
+			in: "foo=%zz",
+			err: &InvalidParameterError{"foo=%zz",
+				url.EscapeError("%zz")},
+		},
+	}
+	for i, fixture := range fixtures {
+		out, err := ParseQuery(fixture.in)
+		if !reflect.DeepEqual(fixture.err, err) {
+			t.Errorf("expected err=%v; got %v (i=%d)", fixture.err, err, i)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if !reflect.DeepEqual(out.Values, fixture.out) {
+			t.Errorf("expected %#v; got %#v (i=%d)", fixture.out, out.Values, i)
+		}
+	}
+}
+
+func TestParseQueryUnmarshal(t *testing.T) {
+	query := "foo[][id]=1&foo[][name]=a&foo[][pointer][pint]=5&foo[][slice]=1,2" +
+		"&foo[][id]=2&foo[][name]=b&foo[][pointer][pint]=0&foo[][slice]=3"
+	expected := structSlice{Foos: []foo{
+		{1, "a", pointer{pint(5)}, []int{1, 2}},
+		{2, "b", pointer{pint(0)}, []int{3}},
+	}}
+	m, err := ParseQuery(query)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %v", err)
+	}
+	var v structSlice
+	if err := Unmarshal(m, &v); err != nil {
+		t.Fatalf("expected err=nil; got %v", err)
+	}
+	if !reflect.DeepEqual(expected, v) {
+		t.Errorf("expected %v; got %v", expected, v)
+	}
+}
+
+func TestParseQueryLimits(t *testing.T) {
+	fixtures := []struct {
+		o   DecoderOptions
+		in  string
+		err error
+	}{
+		// 0
+		{
+			o:   DecoderOptions{MaxDepth: 2},
+			in:  "a[b][]=1&a[b][c][d]=2",
+			err: &LimitError{DepthLimit, "a[b][c][d]", 2},
+		},
+		// 1
+		{
+			o:   DecoderOptions{MaxKeys: 2},
+			in:  "a=1&a=2&b=3",
+			err: &LimitError{KeyCountLimit, "b", 2},
+		},
+		// 2
+		{
+			o:   DecoderOptions{MaxArrayLength: 2},
+			in:  "a[]=1&b=2&a[]=3&a[]=4",
+			err: &LimitError{ArrayLengthLimit, "a[]", 2},
+		},
+		// 3
+		{
+			o:   DecoderOptions{MaxArrayLength: 1},
+			in:  "a[][id]=1&a[][name]=x&a[][id]=2",
+			err: &LimitError{ArrayLengthLimit, "a[][id]", 1},
+		},
+		// 4
+		{
+			o:   DecoderOptions{MaxValueSize: 3},
+			in:  "a=abc&b=%41%42%43%44",
+			err: &LimitError{ValueSizeLimit, "b", 3},
+		},
+		// 5
+		{
+			o:  DecoderOptions{MaxDepth: 2, MaxKeys: 4, MaxArrayLength: 2},
+			in: "a[b][]=1&a[b][]=2&a[c]=abc&d=1",
+		},
+		// 6
+		{
+			o:   DecoderOptions{Dialect: PHP, MaxArrayLength: 2},
+			in:  "a[]=1&a[]=2&a[0]=3&a[]=4",
+			err: &LimitError{ArrayLengthLimit, "a[]", 2},
+		},
+		// 7
+		{
+			o:   DecoderOptions{Dialect: PHP, MaxArrayLength: 2},
+			in:  "a[0][id]=1&a[5][id]=2&a[9][id]=3",
+			err: &LimitError{ArrayLengthLimit, "a[9][id]", 2},
+		},
+		// 8
+		{
+			o:   DecoderOptions{Dialect: QS, MaxArrayLength: 1},
+			in:  "a[b][]=1&a[b][]=2",
+			err: &LimitError{ArrayLengthLimit, "a[b][]", 1},
+		},
+		// 9
+		{
+			o:  DecoderOptions{Dialect: QS, MaxArrayLength: 2},
+			in: "a[]=1&a[]=2&a[1]=3&a[x]=4&a[y][]=5",
+		},
+		// 10
+		{
+			o:  DecoderOptions{MaxKeys: 2},
+			in: "&a=1&&&b=2&",
+		},
+	}
+	for i, fixture := range fixtures {
+		_, err := fixture.o.ParseQuery(fixture.in)
+		if !reflect.DeepEqual(fixture.err, err) {
+			t.Errorf("expected err=%v; got %v (i=%d)", fixture.err, err, i)
+		}
+	}
+	o := DecoderOptions{MaxValueSize: 10}
+	key := strings.Repeat("k", keySpaceLimit-1)
+	if _, err := o.ParseQuery(key + "=" + strings.Repeat("%41", 10) +
+		"&b=1"); err != nil {
+		t.Errorf("expected err=nil; got %v", err)
+	}
+	r := strings.NewReader("a%5Bb%5D=" + strings.Repeat("x", 1<<20) + "&c=1")
+	expected := &LimitError{ValueSizeLimit, "a[b]", 10}
+	if _, err := o.parse(r); !reflect.DeepEqual(err, expected) {
+		t.Errorf("expected err=%v; got %v", expected, err)
+	}
+	if r.Len() < 1<<19 {
+		t.Errorf("expected the param not to be read as a whole; %d bytes left",
+			r.Len())
+	}
+}
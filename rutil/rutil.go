@@ -11,7 +11,7 @@ func ParseURL(link string) (railing.Values, error) {
 	if err != nil {
 		return railing.Values{}, err
 	}
-	return railing.Values{Values: u.Query()}, nil
+	return railing.ParseQuery(u.RawQuery)
 }
 
 func UnmarshalURL(link string, v interface{}) error {
@@ -0,0 +1,75 @@
+package railing
+
+import (
+	"io"
+	"reflect"
+)
+
+// An Encoder writes query strings to an output stream.
+type Encoder struct {
+	w io.Writer
+	o EncoderOptions
+}
+
+// NewEncoder returns a new encoder that writes to w with the default options.
+func NewEncoder(w io.Writer) *Encoder {
+	return EncoderOptions{}.NewEncoder(w)
+}
+
+// NewEncoder returns a new encoder that writes to w with the options.
+func (o EncoderOptions) NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w, o: o}
+}
+
+// Encode writes the query string of v to the stream. The query string is the
+// same as the one built by MarshalAppend, but it is written in chunks, as the
+// pairs are encoded, so that large forms are never held in memory as a whole.
+// Nothing follows the query string, calling Encode again writes a separate
+// query string.
+//
+// Since the chunks are written as soon as they are encoded, an error, eg. the
+// one returned by MarshalText of a field, may leave a part of the query string
+// in the stream. Encode v with MarshalAppend first if it must be written
+// entirely or not at all.
+func (e *Encoder) Encode(v interface{}) error {
+	a := newAppender(e.o, make([]byte, 0, flushSize), e.w)
+	if err := a.value(reflect.ValueOf(v)); err != nil {
+		return err
+	}
+	a.flush()
+	return a.err
+}
+
+// A Decoder reads query strings from an input stream.
+type Decoder struct {
+	r io.Reader
+	o DecoderOptions
+}
+
+// NewDecoder returns a new decoder that reads from r with the default options.
+func NewDecoder(r io.Reader) *Decoder {
+	return DecoderOptions{}.NewDecoder(r)
+}
+
+// NewDecoder returns a new decoder that reads from r with the options.
+func (o DecoderOptions) NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r, o: o}
+}
+
+// Decode reads the query string from the stream until EOF and stores it in
+// the value pointed to by v. The stream is read param by param, so that the
+// query string is never held in memory as a whole, and the limits of the
+// options are checked as soon as every param is read. However, the parsed
+// params are kept in memory until the stream ends and they are stored in v.
+// See ParseQuery and Unmarshal for details.
+func (d *Decoder) Decode(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return &InvalidUnmarshalError{reflect.TypeOf(v)}
+	}
+	m, err := d.o.parse(d.r)
+	if err != nil {
+		return err
+	}
+	return d.o.decoder().unmarshal(m, rv)
+}
@@ -0,0 +1,223 @@
+package railing
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+type countingWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(p)
+}
+
+func TestEncoderDecoder(t *testing.T) {
+	typ, m := largeForm(30)
+	in := reflect.New(typ)
+	if err := Unmarshal(m, in.Interface()); err != nil {
+		t.Fatalf("expected err=nil; got %v", err)
+	}
+	expected, err := MarshalAppend(nil, in.Interface())
+	if err != nil {
+		t.Fatalf("expected err=nil; got %v", err)
+	}
+	var w countingWriter
+	if err := NewEncoder(&w).Encode(in.Interface()); err != nil {
+		t.Fatalf("expected err=nil; got %v", err)
+	}
+	if w.String() != string(expected) {
+		t.Errorf("expected %s; got %s", expected, w.String())
+	}
+	if w.writes < 2 {
+		t.Errorf("expected the query string written in chunks; got %d writes",
+			w.writes)
+	}
+	out := reflect.New(typ)
+	dec := NewDecoder(iotest.OneByteReader(&w.Buffer))
+	if err := dec.Decode(out.Interface()); err != nil {
+		t.Fatalf("expected err=nil; got %v", err)
+	}
+	if !reflect.DeepEqual(out.Interface(), in.Interface()) {
+		t.Errorf("expected %v; got %v", in.Interface(), out.Interface())
+	}
+}
+
+func TestEncoderOptions(t *testing.T) {
+	in := orders{Orders: []order{{ID: 1, Items: []int{1}}}}
+	var buf bytes.Buffer
+	o := EncoderOptions{Ordered: true, Indexed: true, Dialect: PHP}
+	if err := o.NewEncoder(&buf).Encode(in); err != nil {
+		t.Fatalf("expected err=nil; got %v", err)
+	}
+	expected, err := o.MarshalAppend(nil, in)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %v", err)
+	}
+	if buf.String() != string(expected) {
+		t.Errorf("expected %s; got %s", expected, buf.String())
+	}
+	var out orders
+	dec := DecoderOptions{Dialect: PHP}.NewDecoder(&buf)
+	if err := dec.Decode(&out); err != nil {
+		t.Fatalf("expected err=nil; got %v", err)
+	}
+	if !reflect.DeepEqual(out, in) {
+		t.Errorf("expected %v; got %v", in, out)
+	}
+}
+
+func TestEncoderDecoderBools(t *testing.T) {
+	in := checkBoxes{Admin: true, Rating: []bool{false, true}}
+	var buf bytes.Buffer
+	enc := EncoderOptions{NumericBools: true}.NewEncoder(&buf)
+	if err := enc.Encode(in); err != nil {
+		t.Fatalf("expected err=nil; got %v", err)
+	}
+	expected := "admin=1&name=&rating%5B%5D=0&rating%5B%5D=1"
+	if buf.String() != expected {
+		t.Errorf("expected %s; got %s", expected, buf.String())
+	}
+	buf.WriteString("&admin=no")
+	var out checkBoxes
+	o := DecoderOptions{RailsBools: true, Duplicates: FirstValue}
+	dec := o.NewDecoder(&buf)
+	if err := dec.Decode(&out); err != nil {
+		t.Fatalf("expected err=nil; got %v", err)
+	}
+	if !reflect.DeepEqual(out, in) {
+		t.Errorf("expected %v; got %v", in, out)
+	}
+}
+
+func TestEncoderDecoderBareNils(t *testing.T) {
+	empty := ""
+	in := nullable{Title: &empty}
+	var buf bytes.Buffer
+	enc := EncoderOptions{BareNils: true}.NewEncoder(&buf)
+	if err := enc.Encode(in); err != nil {
+		t.Fatalf("expected err=nil; got %v", err)
+	}
+	expected := "address&age=0&name&note&title="
+	if buf.String() != expected {
+		t.Errorf("expected %s; got %s", expected, buf.String())
+	}
+	out := nullable{Name: &empty, Address: &address{}}
+	dec := DecoderOptions{BareNils: true}.NewDecoder(&buf)
+	if err := dec.Decode(&out); err != nil {
+		t.Fatalf("expected err=nil; got %v", err)
+	}
+	if !reflect.DeepEqual(out, in) {
+		t.Errorf("expected %v; got %v", in, out)
+	}
+}
+
+func TestEncoderDecoderEmptyArrays(t *testing.T) {
+	in := tagged{Tags: []string{}, IDs: []int{}, Items: []item{}}
+	var buf bytes.Buffer
+	enc := EncoderOptions{EmptyArrays: true}.NewEncoder(&buf)
+	if err := enc.Encode(in); err != nil {
+		t.Fatalf("expected err=nil; got %v", err)
+	}
+	expected := "ids=&items%5B%5D=&tags%5B%5D="
+	if buf.String() != expected {
+		t.Errorf("expected %s; got %s", expected, buf.String())
+	}
+	var out tagged
+	dec := DecoderOptions{EmptyArrays: true}.NewDecoder(&buf)
+	if err := dec.Decode(&out); err != nil {
+		t.Fatalf("expected err=nil; got %v", err)
+	}
+	if !reflect.DeepEqual(out, in) {
+		t.Errorf("expected %v; got %v", in, out)
+	}
+}
+
+func TestDecoderLimits(t *testing.T) {
+	r := strings.NewReader(strings.Repeat("tags[]=a&", 1000))
+	o := DecoderOptions{MaxDepth: 1, MaxKeys: 10, MaxArrayLength: 100,
+		MaxValueSize: 1}
+	dec := o.NewDecoder(r)
+	var out tagged
+	expected := &LimitError{KeyCountLimit, "tags[]", 10}
+	if err := dec.Decode(&out); !reflect.DeepEqual(err, expected) {
+		t.Errorf("expected err=%v; got %v", expected, err)
+	}
+	if r.Len() == 0 {
+		t.Error("expected the stream not to be read to the end")
+	}
+}
+
+func TestEncoderDecoderErrors(t *testing.T) {
+	errWrite := errors.New("write")
+	if err := NewEncoder(errWriter{errWrite}).Encode(order{}); err != errWrite {
+		t.Errorf("expected err=%v; got %v", errWrite, err)
+	}
+	errRead := errors.New("read")
+	r := iotest.TimeoutReader(strings.NewReader("id=1&id=2"))
+	var o order
+	if err := NewDecoder(r).Decode(&o); err == nil {
+		t.Error("expected an error; got nil")
+	}
+	if err := NewDecoder(errReader{errRead}).Decode(&o); err != errRead {
+		t.Errorf("expected err=%v; got %v", errRead, err)
+	}
+	err := NewDecoder(strings.NewReader("id=%zz")).Decode(&o)
+	if _, ok := err.(*InvalidParameterError); !ok {
+		t.Errorf("expected InvalidParameterError; got %v", err)
+	}
+	expected := &InvalidUnmarshalError{reflect.TypeOf(o)}
+	sr := strings.NewReader("id=1")
+	if err := NewDecoder(sr).Decode(o); !reflect.DeepEqual(err, expected) {
+		t.Errorf("expected err=%v; got %v", expected, err)
+	}
+	if sr.Len() == 0 {
+		t.Error("expected the stream not to be read")
+	}
+}
+
+func TestEncoderPartialWrite(t *testing.T) {
+	v := struct {
+		Tags  []string `railing:"a"`
+		Level level    `railing:"z"`
+	}{Level: 2}
+	for i := 0; i < flushSize; i++ {
+		v.Tags = append(v.Tags, "x")
+	}
+	var buf bytes.Buffer
+	err := NewEncoder(&buf).Encode(v)
+	if err == nil {
+		t.Fatal("expected an error; got nil")
+	}
+	if !strings.HasPrefix(buf.String(), "a%5B%5D=x&a%5B%5D=x") {
+		t.Errorf("expected the first chunk to be written; got %q",
+			buf.String())
+	}
+	if strings.Contains(buf.String(), "z=") {
+		t.Errorf("expected the query string to stop before z; got %q",
+			buf.String())
+	}
+}
+
+type errWriter struct {
+	err error
+}
+
+func (w errWriter) Write(p []byte) (int, error) {
+	return 0, w.err
+}
+
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
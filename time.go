@@ -0,0 +1,236 @@
+package railing
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+// appendTime appends time.Time or time.Duration under v formatted according
+// to the options of the tag to dst. It returns false if v is neither of them,
+// or if it is time.Time and the tag has no options for it - it is then encoded
+// by its MarshalText in RFC 3339 format.
+//
+//   - layout=2006-01-02 - time.Time formatted with the layout
+//   - unix              - time.Time as the seconds since the Unix epoch
+//   - seconds           - time.Duration as the number of seconds
+//
+// Without the seconds option time.Duration is formatted by its String method,
+// eg. "1m30s".
+func appendTime(dst []byte, v reflect.Value, tag tag) ([]byte, bool) {
+	switch v.Type() {
+	case timeType:
+		t := v.Interface().(time.Time)
+		switch {
+		case tag.unix:
+			sec, nsec := t.Unix(), int64(t.Nanosecond())
+			if sec < 0 && nsec > 0 {
+				return appendSeconds(dst, true, uint64(-(sec + 1)),
+					uint64(1e9-nsec)), true
+			}
+			if sec < 0 {
+				return appendSeconds(dst, true, uint64(-sec), 0), true
+			}
+			return appendSeconds(dst, false, uint64(sec), uint64(nsec)), true
+		case tag.layout != "":
+			return t.AppendFormat(dst, tag.layout), true
+		}
+	case durationType:
+		d := time.Duration(v.Int())
+		if !tag.seconds {
+			return append(dst, d.String()...), true
+		}
+		u := uint64(d)
+		if d < 0 {
+			u = -u
+		}
+		return appendSeconds(dst, d < 0, u/1e9, u%1e9), true
+	}
+	return dst, false
+}
+
+// appendSeconds appends the number of seconds with the fraction of nsec
+// nanoseconds, eg. "-1.5", to dst. The fraction is written without trailing
+// zeros, and it is omitted if nsec is 0.
+func appendSeconds(dst []byte, neg bool, sec, nsec uint64) []byte {
+	if neg {
+		dst = append(dst, '-')
+	}
+	dst = strconv.AppendUint(dst, sec, 10)
+	if nsec == 0 {
+		return dst
+	}
+	var frac [9]byte
+	for i := len(frac) - 1; i >= 0; i-- {
+		frac[i] = byte('0' + nsec%10)
+		nsec /= 10
+	}
+	return append(append(dst, '.'), strings.TrimRight(string(frac[:]), "0")...)
+}
+
+// parseTime sets time.Time or time.Duration under v to the value parsed
+// according to the options of the tag, the same way appendTime formats it. It
+// returns false if v is neither of them, or if it is time.Time and the tag has
+// no options for it.
+//
+// time.Duration is parsed by time.ParseDuration, eg. "1m30s", or as the number
+// of seconds, eg. "90", whether or not the tag has the seconds option.
+func parseTime(s string, v reflect.Value, tag tag) (bool, error) {
+	switch v.Type() {
+	case timeType:
+		switch {
+		case tag.unix:
+			neg, sec, nsec, ok := parseSeconds(s)
+			if !ok || sec > 1<<63-1 {
+				return true, &UnmarshalTypeError{"time " + s, v.Type()}
+			}
+			t := time.Unix(int64(sec), int64(nsec))
+			if neg {
+				t = time.Unix(-int64(sec), -int64(nsec))
+			}
+			v.Set(reflect.ValueOf(t.UTC()))
+			return true, nil
+		case tag.layout != "":
+			t, err := time.Parse(tag.layout, s)
+			if err != nil {
+				return true, &UnmarshalTypeError{"time " + s, v.Type()}
+			}
+			v.Set(reflect.ValueOf(t))
+			return true, nil
+		}
+	case durationType:
+		if d, err := time.ParseDuration(s); err == nil {
+			v.SetInt(int64(d))
+			return true, nil
+		}
+		neg, sec, nsec, ok := parseSeconds(s)
+		if !ok || sec > (1<<63-1-nsec)/1e9 {
+			return true, &UnmarshalTypeError{"duration " + s, v.Type()}
+		}
+		d := time.Duration(sec*1e9 + nsec)
+		if neg {
+			d = -d
+		}
+		v.SetInt(int64(d))
+		return true, nil
+	}
+	return false, nil
+}
+
+// parseSeconds parses the number of seconds with an optional sign and up to 9
+// digits of the fraction, eg. "-1.5". It returns false if s is not such number.
+func parseSeconds(s string) (neg bool, sec, nsec uint64, ok bool) {
+	if strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+") {
+		neg, s = s[0] == '-', s[1:]
+	}
+	whole, frac := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		whole, frac = s[:i], s[i+1:]
+	}
+	if whole == "" || len(frac) > 9 {
+		return false, 0, 0, false
+	}
+	sec, err := strconv.ParseUint(whole, 10, 64)
+	if err != nil {
+		return false, 0, 0, false
+	}
+	for i := 0; i < 9; i++ {
+		nsec *= 10
+		if i >= len(frac) {
+			continue
+		}
+		if frac[i] < '0' || frac[i] > '9' {
+			return false, 0, 0, false
+		}
+		nsec += uint64(frac[i] - '0')
+	}
+	return neg, sec, nsec, true
+}
+
+// multiparamKey returns the key of the i-th part of the Rails multiparameter
+// attribute, eg. "published_at(1i)" for the year.
+func multiparamKey(name string, i int) string {
+	return name + "(" + strconv.Itoa(i+1) + "i)"
+}
+
+// multiparam adds time.Time under v as the Rails multiparameter attribute, the
+// way date_select and datetime_select send it - the year, month, day, hour and
+// minute under the keys "name(1i)" to "name(5i)", and the second under
+// "name(6i)" if it is not zero. The time is encoded in UTC, so that it is
+// decoded back as the same instant.
+func (e *encoder) multiparam(tag tag, values *OrderedValues, v reflect.Value) {
+	t := v.Interface().(time.Time).UTC()
+	parts := []int{t.Year(), int(t.Month()), t.Day(), t.Hour(), t.Minute()}
+	if t.Second() != 0 {
+		parts = append(parts, t.Second())
+	}
+	for i, n := range parts {
+		values.Set(multiparamKey(tag.name, i), strconv.Itoa(n))
+	}
+}
+
+// multiparam unmarshals the Rails multiparameter attribute, eg.
+// "published_at(1i)=2024&published_at(2i)=5&published_at(3i)=17", into
+// time.Time or a pointer to it under v. The hour, minute and second default to
+// zero, and the time is in UTC. It does nothing if v is not time.Time or if
+// the index holds no unused parts of the attribute, and the value is left
+// untouched if all the parts are empty, the way rails casts them to nil. A
+// missing year, month or day is reported by its key.
+func (d *decoder) multiparam(idx *keyIndex, tag tag, v reflect.Value) error {
+	typ := v.Type()
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ != timeType {
+		return nil
+	}
+	var parts [6]string
+	found, blank := false, true
+	for i := range parts {
+		key := multiparamKey(tag.name, i)
+		vals, ok := idx.lookup(key)
+		if !ok {
+			continue
+		}
+		if len(vals) > 0 {
+			parts[i] = d.scalar(vals)
+		}
+		found, blank = true, blank && parts[i] == ""
+		idx.use(key)
+	}
+	if !found || blank {
+		return nil
+	}
+	var n [6]int
+	for i, p := range parts {
+		if p == "" && i >= 3 {
+			continue
+		}
+		if p == "" {
+			return &UnmarshalTypeError{"time without " +
+				multiparamKey(tag.name, i), typ}
+		}
+		x, err := strconv.Atoi(p)
+		if err != nil {
+			return &UnmarshalTypeError{"number " + p, typ}
+		}
+		n[i] = x
+	}
+	t := time.Date(n[0], time.Month(n[1]), n[2], n[3], n[4], n[5], 0, time.UTC)
+	if t.Year() != n[0] || int(t.Month()) != n[1] || t.Day() != n[2] ||
+		t.Hour() != n[3] || t.Minute() != n[4] || t.Second() != n[5] {
+		return &UnmarshalTypeError{fmt.Sprintf(
+			"time %04d-%02d-%02d %02d:%02d:%02d", n[0], n[1], n[2], n[3], n[4],
+			n[5]), typ}
+	}
+	_, v = d.indirect(v)
+	v.Set(reflect.ValueOf(t))
+	return nil
+}
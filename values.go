@@ -1,64 +1,475 @@
 package railing
 
 import (
-	"bytes"
-	"fmt"
 	"net/url"
+	"reflect"
 	"sort"
 	"strings"
 )
 
-const leftBracket = "%5B"
-const rightBracket = "%5D"
-const brackets = leftBracket + rightBracket
+// Pair is a single key and value of Values.
+type Pair struct {
+	Key   string
+	Value string
+}
 
 // Values wraps url.Values. It provides it's own Encode function in order to
-// build a query string compatible with rack's parser.
+// build a query string compatible with rack's parser. Values do not keep the
+// order of the pairs, Encode sorts them by key, see OrderedValues.
 type Values struct {
 	url.Values
 }
 
-// Encode encodes the values into “URL encoded” form. Keys are sorted by key.
-// However, in case of object array, every object element is sorted within.
+// NewValues returns Values holding the given pairs.
+func NewValues(pairs ...Pair) Values {
+	v := Values{make(url.Values)}
+	for _, p := range pairs {
+		v.Add(p.Key, p.Value)
+	}
+	return v
+}
+
+// SetNil sets the key without a value, eg. "name" rather than "name=", which
+// rack parses as nil. It replaces any existing values. The key holds an empty
+// slice in url.Values, and an empty value in Pairs.
+func (v *Values) SetNil(key string) {
+	if v.Values == nil {
+		v.Values = make(url.Values)
+	}
+	v.Values[key] = []string{}
+}
+
+// IsNil reports whether the key is set without a value.
+func (v Values) IsNil(key string) bool {
+	return OrderedValues{Values: v.Values}.IsNil(key)
+}
+
+// Pairs returns the keys and values in the order they are written by Encode.
+func (v Values) Pairs() []Pair {
+	return OrderedValues{Values: v.Values}.Pairs()
+}
+
+// Object returns the Values of the object nested under the key, with the keys
+// stripped from the key of the object, eg. "city" for "address[city]". The
+// url.Values of the result are nil if there is no such object.
+func (v Values) Object(key string) Values {
+	return Values{OrderedValues{Values: v.Values}.Object(key).Values}
+}
+
+// SetObject sets the pairs of obj as the object nested under the key, eg.
+// "address[city]" for "city" under "address". It replaces any existing values
+// of the nested keys.
+func (v *Values) SetObject(key string, obj Values) {
+	if v.Values == nil {
+		v.Values = make(url.Values)
+	}
+	o := OrderedValues{Values: v.Values}
+	o.SetObject(key, OrderedValues{Values: obj.Values})
+}
+
+// Elements returns the elements of the array of objects under the key, eg.
+// "orders[][id]" or "orders[0][id]", divided the same way Unmarshal divides
+// them into the elements of a slice of structs. An element of an array of
+// simple values, eg. "items[0]", holds its value under the empty key. It
+// returns nil if there is no such array.
+func (v Values) Elements(key string) ([]Values, error) {
+	elems, err := OrderedValues{Values: v.Values}.Elements(key)
+	if err != nil || elems == nil {
+		return nil, err
+	}
+	res := make([]Values, len(elems))
+	for i, elem := range elems {
+		res[i] = Values{elem.Values}
+	}
+	return res, nil
+}
+
+// SetElements sets the elements as the array of objects under the key, eg.
+// "orders[][id]", the same way Marshal encodes a slice of structs. The values
+// of the keys of an element which are not arrays are joined by a comma.
+func (v *Values) SetElements(key string, elems []Values) {
+	if v.Values == nil {
+		v.Values = make(url.Values)
+	}
+	o := OrderedValues{Values: v.Values}
+	ordered := make([]OrderedValues, len(elems))
+	for i, elem := range elems {
+		ordered[i] = OrderedValues{Values: elem.Values}
+	}
+	o.SetElements(key, ordered)
+}
+
+// Encode encodes the values into “URL encoded” form sorted by key. However,
+// in case of object array, every object element is sorted within. Nil keys
+// are encoded without '=', eg. "name".
 //
 // objects[][id]=1&objects[][name]=name1&objects[][id]=2&objects[][name]=name2
 func (v *Values) Encode() string {
-	if v == nil || v.Values == nil {
+	if v == nil {
+		return ""
+	}
+	return (&OrderedValues{Values: v.Values}).Encode()
+}
+
+// AppendEncode appends the values encoded the same way Encode does it to dst
+// and returns the extended buffer.
+func (v *Values) AppendEncode(dst []byte) []byte {
+	if v == nil {
+		return dst
+	}
+	return (&OrderedValues{Values: v.Values}).AppendEncode(dst)
+}
+
+// OrderedValues are Values which keep the list of pairs in the order they were
+// added besides url.Values. Encode writes them in that order, so that parsing
+// and encoding the query string gives back the same byte sequence. The
+// OrderedValues are returned by ParseQueryOrdered, MarshalOrdered and
+// FromTree, and UnmarshalOrdered divides the arrays of objects the way rack
+// does it, by the order of the pairs.
+//
+// The pairs refer to the values of the embedded url.Values, so that a value
+// changed directly in url.Values, eg. by its Set method, is encoded in its
+// place. If values are added to or deleted from url.Values directly, the pairs
+// no longer match them and OrderedValues are encoded as if they were not
+// ordered, so that no value is lost. A composite literal, eg.
+// OrderedValues{Values: m}, is not ordered.
+type OrderedValues struct {
+	url.Values
+	pairs   []pairRef
+	ordered bool
+}
+
+// pairRef refers to the i-th value of the key in url.Values. The only pair of
+// a nil key refers to its 0th value.
+type pairRef struct {
+	key string
+	i   int
+}
+
+// NewOrderedValues returns OrderedValues holding the given pairs.
+func NewOrderedValues(pairs ...Pair) OrderedValues {
+	v := OrderedValues{Values: make(url.Values), ordered: true}
+	for _, p := range pairs {
+		v.Add(p.Key, p.Value)
+	}
+	return v
+}
+
+// Ordered reports whether v keeps the order of its pairs, ie. whether they
+// were not created as unordered values and they still refer to every value of
+// url.Values. It does not allocate.
+func (v OrderedValues) Ordered() bool {
+	if !v.ordered {
+		return false
+	}
+	n := 0
+	for _, vals := range v.Values {
+		if len(vals) == 0 {
+			n++
+		}
+		n += len(vals)
+	}
+	if n != len(v.pairs) {
+		return false
+	}
+	// The pairs are distinct, so if each of them refers to an existing value,
+	// together they refer to all of them.
+	for _, p := range v.pairs {
+		vals, ok := v.Values[p.key]
+		if !ok || p.i >= len(vals) && p.i > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// pair returns the key and the value which p refers to.
+func (v OrderedValues) pair(p pairRef) Pair {
+	if vals := v.Values[p.key]; p.i < len(vals) {
+		return Pair{p.key, vals[p.i]}
+	}
+	return Pair{p.key, ""}
+}
+
+// Add adds the value to key. It appends to any existing values associated with
+// key. If the key is nil, the value replaces it.
+func (v *OrderedValues) Add(key, value string) {
+	if v.Values == nil {
+		v.Values = make(url.Values)
+	}
+	if vals, ok := v.Values[key]; ok && len(vals) == 0 {
+		v.Set(key, value)
+		return
+	}
+	if v.ordered {
+		v.pairs = append(v.pairs, pairRef{key, len(v.Values[key])})
+	}
+	v.Values.Add(key, value)
+}
+
+// Set sets the key to value. It replaces any existing values. The value takes
+// the position of the first replaced value.
+func (v *OrderedValues) Set(key, value string) {
+	if v.Values == nil {
+		v.Values = make(url.Values)
+	}
+	if _, ok := v.Values[key]; !ok {
+		v.Add(key, value)
+		return
+	}
+	v.Values.Set(key, value)
+	if !v.ordered {
+		return
+	}
+	pairs, set := make([]pairRef, 0, len(v.pairs)), false
+	for _, p := range v.pairs {
+		switch {
+		case p.key != key:
+			pairs = append(pairs, p)
+		case !set:
+			pairs, set = append(pairs, pairRef{key, 0}), true
+		}
+	}
+	if !set {
+		pairs = append(pairs, pairRef{key, 0})
+	}
+	v.pairs = pairs
+}
+
+// SetNil sets the key without a value, see Values.SetNil.
+func (v *OrderedValues) SetNil(key string) {
+	v.Set(key, "")
+	v.Values[key] = []string{}
+}
+
+// IsNil reports whether the key is set without a value.
+func (v OrderedValues) IsNil(key string) bool {
+	vals, ok := v.Values[key]
+	return ok && len(vals) == 0
+}
+
+// Del deletes the values associated with key.
+func (v *OrderedValues) Del(key string) {
+	if _, ok := v.Values[key]; !ok {
+		return
+	}
+	v.Values.Del(key)
+	if !v.ordered {
+		return
+	}
+	pairs := make([]pairRef, 0, len(v.pairs))
+	for _, p := range v.pairs {
+		if p.key != key {
+			pairs = append(pairs, p)
+		}
+	}
+	v.pairs = pairs
+}
+
+// clone returns a copy of v, which can be modified without modifying v.
+func (v OrderedValues) clone() OrderedValues {
+	c := OrderedValues{Values: make(url.Values, len(v.Values)), ordered: v.ordered}
+	for k, vals := range v.Values {
+		c.Values[k] = vals
+	}
+	c.pairs = append([]pairRef(nil), v.pairs...)
+	return c
+}
+
+// Pairs returns the keys and values in the order they are written by Encode.
+func (v OrderedValues) Pairs() []Pair {
+	if v.Ordered() {
+		pairs := make([]Pair, len(v.pairs))
+		for i, p := range v.pairs {
+			pairs[i] = v.pair(p)
+		}
+		return pairs
+	}
+	return v.sortedPairs("", v.Values, nil)
+}
+
+// Object returns the ordered values of the object nested under the key, see
+// Values.Object.
+func (v OrderedValues) Object(key string) OrderedValues {
+	var obj OrderedValues
+	if v.Ordered() {
+		for _, p := range v.Pairs() {
+			if top, sub, ok := splitObject(p.Key); ok && top == key {
+				if obj.Values == nil {
+					obj = NewOrderedValues()
+				}
+				obj.add(sub, p.Value, v.IsNil(p.Key))
+			}
+		}
+		return obj
+	}
+	for k, vals := range v.Values {
+		if top, sub, ok := splitObject(k); ok && top == key {
+			if obj.Values == nil {
+				obj.Values = make(url.Values)
+			}
+			obj.Values[sub] = vals
+		}
+	}
+	return obj
+}
+
+// SetObject sets the pairs of obj as the object nested under the key, see
+// Values.SetObject.
+func (v *OrderedValues) SetObject(key string, obj OrderedValues) {
+	(&encoder{}).mergeByKey(key, obj, v)
+}
+
+// Elements returns the elements of the array of objects under the key, see
+// Values.Elements. The elements are divided the same way UnmarshalOrdered
+// divides them.
+func (v OrderedValues) Elements(key string) ([]OrderedValues, error) {
+	obj := v.Object(key)
+	if obj.Values == nil {
+		return nil, nil
+	}
+	return (&decoder{}).elements(obj, key,
+		reflect.TypeOf([]OrderedValues(nil)))
+}
+
+// SetElements sets the elements as the array of objects under the key, see
+// Values.SetElements.
+func (v *OrderedValues) SetElements(key string, elems []OrderedValues) {
+	e := &encoder{}
+	m := NewOrderedValues()
+	for _, elem := range elems {
+		e.addElement(&m, elem)
+	}
+	e.mergeByKey(key+"[]", m, v)
+}
+
+// add adds the value to key, or sets the key to nil if isNil is true.
+func (v *OrderedValues) add(key, value string, isNil bool) {
+	if isNil {
+		v.SetNil(key)
+		return
+	}
+	v.Add(key, value)
+}
+
+// sortKeys sorts the pairs by their top level keys, eg. "foo" for "foo[][id]".
+// The pairs which share the top level key keep their order.
+func (v *OrderedValues) sortKeys() {
+	sort.SliceStable(v.pairs, func(i, j int) bool {
+		return topKey(v.pairs[i].key) < topKey(v.pairs[j].key)
+	})
+}
+
+// topKey returns the top level key, eg. "foo" for "foo[][id]".
+func topKey(key string) string {
+	if i := strings.IndexByte(key, '['); i > 0 {
+		return key[:i]
+	}
+	return key
+}
+
+// Encode encodes the values into “URL encoded” form in the order of their
+// pairs. If they are not ordered, they are encoded the same way Values.Encode
+// encodes them.
+func (v *OrderedValues) Encode() string {
+	if v == nil {
 		return ""
 	}
-	var buf bytes.Buffer
-	v.encode("", v.Values, &buf)
-	return buf.String()
+	return string(v.appendEncode(nil, appendQueryEscape))
+}
+
+// AppendEncode appends the values encoded the same way Encode does it to dst
+// and returns the extended buffer.
+func (v *OrderedValues) AppendEncode(dst []byte) []byte {
+	if v == nil {
+		return dst
+	}
+	return v.appendEncode(dst, appendQueryEscape)
+}
+
+func (v *OrderedValues) appendEncode(dst []byte,
+	escape func(dst []byte, s string) []byte) []byte {
+	if v.Values == nil {
+		return dst
+	}
+	if !v.Ordered() {
+		for i, p := range v.sortedPairs("", v.Values, nil) {
+			dst = v.appendPair(dst, i, p, escape)
+		}
+		return dst
+	}
+	for i, p := range v.pairs {
+		dst = v.appendPair(dst, i, v.pair(p), escape)
+	}
+	return dst
+}
+
+// appendPair appends the i-th pair to dst. A nil key is written without '='.
+func (v *OrderedValues) appendPair(dst []byte, i int, p Pair,
+	escape func(dst []byte, s string) []byte) []byte {
+	if i > 0 {
+		dst = append(dst, '&')
+	}
+	dst = escape(dst, p.Key)
+	if p.Value == "" && v.IsNil(p.Key) {
+		return dst
+	}
+	dst = append(dst, '=')
+	return escape(dst, p.Value)
+}
+
+const upperhex = "0123456789ABCDEF"
+
+// appendQueryEscape appends s escaped the same way url.QueryEscape does it to
+// dst.
+func appendQueryEscape(dst []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			dst = append(dst, c)
+		case c == ' ':
+			dst = append(dst, '+')
+		default:
+			dst = append(dst, '%', upperhex[c>>4], upperhex[c&15])
+		}
+	}
+	return dst
 }
 
-func (v *Values) encode(topPrefix string, m url.Values, buf *bytes.Buffer) {
+func (v *OrderedValues) sortedPairs(topPrefix string, m url.Values,
+	pairs []Pair) []Pair {
+	idx := newKeyIndex(OrderedValues{Values: m})
 	for _, k := range v.keys(m) {
-		prefix := url.QueryEscape(strings.TrimSuffix(k, "[]"))
+		prefix := strings.TrimSuffix(k, "[]")
 		if topPrefix != "" {
-			prefix = fmt.Sprintf("%s%s%s%s",
-				topPrefix, leftBracket, prefix, rightBracket)
+			prefix = topPrefix + "[" + prefix + "]"
 		}
-		subm, vals := findValues(m, k)
+		subm, vals := idx.find(k)
 		switch {
 		case vals != nil:
-			v.encodeFlat(prefix, strings.HasSuffix(k, "[]"), vals, buf)
+			pairs = v.flatPairs(prefix, strings.HasSuffix(k, "[]"), vals, pairs)
 		case strings.HasSuffix(k, "[]"):
-			v.encodeArray(prefix, subm, buf)
-		default: // subm != nil
-			v.encode(prefix, subm, buf)
+			pairs = v.arrayPairs(prefix, subm.Values, pairs)
+		default:
+			pairs = v.sortedPairs(prefix, subm.Values, pairs)
 		}
 	}
+	return pairs
 }
 
-func (v *Values) keys(m url.Values) []string {
+func (v *OrderedValues) keys(m url.Values) []string {
 	set := make(map[string]struct{})
 	for k := range m {
-		match := reTopKey.FindStringSubmatch(k)
-		if match != nil {
-			set[match[1]+match[3]] = struct{}{}
+		top, rest, ok := splitTop(k)
+		if !ok {
+			set[k] = struct{}{}
 			continue
 		}
-		set[k] = struct{}{}
+		if strings.HasPrefix(rest, "[]") {
+			top += "[]"
+		}
+		set[top] = struct{}{}
 	}
 	keys := make(sort.StringSlice, 0, len(m))
 	for k := range set {
@@ -68,21 +479,22 @@ func (v *Values) keys(m url.Values) []string {
 	return keys
 }
 
-func (v *Values) encodeFlat(prefix string, suffix bool, vals []string,
-	buf *bytes.Buffer) {
+func (v *OrderedValues) flatPairs(prefix string, suffix bool, vals []string,
+	pairs []Pair) []Pair {
+	if suffix {
+		prefix += "[]"
+	}
+	if len(vals) == 0 {
+		return append(pairs, Pair{prefix, ""})
+	}
 	for _, v := range vals {
-		if buf.Len() > 0 {
-			buf.WriteByte('&')
-		}
-		buf.WriteString(prefix)
-		if suffix {
-			buf.WriteString(brackets)
-		}
-		buf.WriteString("=" + url.QueryEscape(v))
+		pairs = append(pairs, Pair{prefix, v})
 	}
+	return pairs
 }
 
-func (v *Values) encodeArray(prefix string, m url.Values, buf *bytes.Buffer) {
+func (v *OrderedValues) arrayPairs(prefix string, m url.Values,
+	pairs []Pair) []Pair {
 	keys := make(sort.StringSlice, 0, len(m))
 	l := 0
 	for k, vals := range m {
@@ -92,24 +504,15 @@ func (v *Values) encodeArray(prefix string, m url.Values, buf *bytes.Buffer) {
 		}
 	}
 	keys.Sort()
-	objs := make([][]string, l)
+	objs := make([][]Pair, l)
 	for _, k := range keys {
+		top, rest, _ := splitTop(k)
 		for i, val := range m[k] {
-			match := reTopKey.FindStringSubmatch(k)
-			objs[i] = append(objs[i], fmt.Sprintf("%s%s%s%s%s%s=%s",
-				prefix,
-				brackets,
-				leftBracket,
-				url.QueryEscape(match[1]),
-				rightBracket,
-				url.QueryEscape(match[2]),
-				url.QueryEscape(val)))
+			objs[i] = append(objs[i], Pair{prefix + "[][" + top + "]" + rest, val})
 		}
 	}
 	for i := range objs {
-		if buf.Len() > 0 {
-			buf.WriteByte('&')
-		}
-		buf.WriteString(strings.Join(objs[i], "&"))
+		pairs = append(pairs, objs[i]...)
 	}
+	return pairs
 }
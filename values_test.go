@@ -2,6 +2,7 @@ package railing
 
 import (
 	"net/url"
+	"reflect"
 	"testing"
 )
 
@@ -87,3 +88,262 @@ func TestEncode(t *testing.T) {
 		}
 	}
 }
+
+func TestEncodeOrdered(t *testing.T) {
+	v := NewOrderedValues(Pair{"z", "1"}, Pair{"a[]", "2"}, Pair{"m[k]", "3"})
+	v.Add("a[]", "4")
+	v.Set("z", "5")
+	v.Add("d", "6")
+	v.Del("m[k]")
+	// "z=5&a[]=2&a[]=4&d=6"
+	expected := "z=5&a%5B%5D=2&a%5B%5D=4&d=6"
+	if out := v.Encode(); out != expected {
+		t.Errorf("expected %s; got %s", expected, out)
+	}
+	if !v.Ordered() {
+		t.Error("expected ordered values")
+	}
+	v.Values["b"] = []string{"7"}
+	// "a[]=2&a[]=4&b=7&d=6&z=5"
+	expected = "a%5B%5D=2&a%5B%5D=4&b=7&d=6&z=5"
+	if out := v.Encode(); out != expected {
+		t.Errorf("expected %s; got %s", expected, out)
+	}
+	if v.Ordered() {
+		t.Error("expected values not to be ordered")
+	}
+}
+
+func TestEncodeOrderedDirectChanges(t *testing.T) {
+	v := NewOrderedValues(Pair{"page", "1"}, Pair{"a", "x"})
+	v.Values.Set("page", "2")
+	if expected, out := "page=2&a=x", v.Encode(); out != expected {
+		t.Errorf("expected %s; got %s", expected, out)
+	}
+	if !v.Ordered() {
+		t.Error("expected ordered values")
+	}
+	c := v
+	c.Add("page", "3")
+	if expected, out := "a=x&page=2&page=3", v.Encode(); out != expected {
+		t.Errorf("expected %s; got %s", expected, out)
+	}
+	if expected, out := "page=2&a=x&page=3", c.Encode(); out != expected {
+		t.Errorf("expected %s; got %s", expected, out)
+	}
+	c.Values.Set("page", "4")
+	expected := []Pair{{"a", "x"}, {"page", "4"}}
+	if pairs := c.Pairs(); !reflect.DeepEqual(pairs, expected) {
+		t.Errorf("expected %v; got %v", expected, pairs)
+	}
+	c.Values.Del("a")
+	c.Values.Add("b", "y")
+	if expected, out := "b=y&page=4", c.Encode(); out != expected {
+		t.Errorf("expected %s; got %s", expected, out)
+	}
+}
+
+func TestRoundTripOrdered(t *testing.T) {
+	// "foo[][id]=2&foo[][name]=b&foo[][pointer][pint]=0&foo[][slice]=3&
+	//  foo[][id]=1&foo[][name]=a&foo[][pointer][pint]=5&foo[][slice]=1,2"
+	query := "foo%5B%5D%5Bid%5D=2&foo%5B%5D%5Bname%5D=b&foo%5B%5D%5Bpointer%5D" +
+		"%5Bpint%5D=0&foo%5B%5D%5Bslice%5D=3&foo%5B%5D%5Bid%5D=1&foo%5B%5D%5B" +
+		"name%5D=a&foo%5B%5D%5Bpointer%5D%5Bpint%5D=5&foo%5B%5D%5Bslice%5D=1%2C2"
+	m, err := ParseQueryOrdered(query)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %v", err)
+	}
+	if encoded := m.Encode(); encoded != query {
+		t.Errorf("expected %s; got %s", query, encoded)
+	}
+	var v structSlice
+	if err := UnmarshalOrdered(m, &v); err != nil {
+		t.Fatalf("expected err=nil; got %v", err)
+	}
+	val, err := EncoderOptions{Ordered: true}.MarshalOrdered(&v)
+	if err != nil {
+		t.Fatalf("expected err=nil; got %v", err)
+	}
+	if encoded := val.Encode(); encoded != query {
+		t.Errorf("expected %s; got %s", query, encoded)
+	}
+}
+
+func TestAppendEncodeAllocs(t *testing.T) {
+	m, err := ParseQueryOrdered("foo[][id]=1&foo[][name]=a+b&foo[][id]=2&bar=%26")
+	if err != nil {
+		t.Fatalf("expected err=nil; got %v", err)
+	}
+	dst := make([]byte, 0, 128)
+	allocs := testing.AllocsPerRun(100, func() {
+		dst = m.AppendEncode(dst[:0])
+	})
+	if allocs != 0 {
+		t.Errorf("expected 0 allocs; got %v", allocs)
+	}
+	if !m.Ordered() {
+		t.Error("expected ordered values")
+	}
+}
+
+func TestAppendQueryEscape(t *testing.T) {
+	b := make([]byte, 256)
+	for c := range b {
+		b[c] = byte(c)
+	}
+	s := string(b) + "zażółć gęślą jaźń"
+	if out := string(appendQueryEscape(nil, s)); out != url.QueryEscape(s) {
+		t.Errorf("expected %s; got %s", url.QueryEscape(s), out)
+	}
+}
+
+func TestValuesObject(t *testing.T) {
+	fixtures := []struct {
+		in       OrderedValues
+		key      string
+		expected []Pair
+	}{
+		// 0
+		{
+			in: NewOrderedValues(Pair{"a[x]", "1"}, Pair{"b[y]", "2"},
+				Pair{"a[z][]", "3"}, Pair{"a", "4"}),
+			key:      "a",
+			expected: []Pair{{"x", "1"}, {"z[]", "3"}},
+		},
+		// 1
+		{
+			in: OrderedValues{Values: url.Values{
+				"a[][id]": {"1", "2"},
+				"a[]":     {"3"},
+			}},
+			key:      "a",
+			expected: []Pair{{"id", "1"}, {"id", "2"}},
+		},
+		// 2
+		{
+			in:       NewOrderedValues(Pair{"a", "1"}, Pair{"a[]", "2"}),
+			key:      "a",
+			expected: nil,
+		},
+	}
+	for i, fixture := range fixtures {
+		obj := fixture.in.Object(fixture.key)
+		if (obj.Values == nil) != (fixture.expected == nil) {
+			t.Errorf("expected %v; got %v (i=%d)", fixture.expected, obj.Values, i)
+			continue
+		}
+		if pairs := obj.Pairs(); !reflect.DeepEqual(pairs, fixture.expected) &&
+			len(pairs)+len(fixture.expected) > 0 {
+			t.Errorf("expected %v; got %v (i=%d)", fixture.expected, pairs, i)
+		}
+	}
+}
+
+func TestValuesElements(t *testing.T) {
+	fixtures := []struct {
+		in       OrderedValues
+		expected [][]Pair
+		err      error
+	}{
+		// 0
+		{
+			in: NewOrderedValues(Pair{"a[][id]", "1"}, Pair{"a[][tags][]", "x"},
+				Pair{"a[][id]", "2"}),
+			expected: [][]Pair{
+				{{"id", "1"}, {"tags[]", "x"}},
+				{{"id", "2"}},
+			},
+		},
+		// 1
+		{
+			in:       NewOrderedValues(Pair{"a[1]", "y"}, Pair{"a[0]", "x"}),
+			expected: [][]Pair{{{"", "x"}}, {{"", "y"}}},
+		},
+		// 2
+		{
+			in: OrderedValues{Values: url.Values{
+				"a[][id]":   {"1", "2"},
+				"a[][name]": {"x"},
+			}},
+			err: errUnevenElements,
+		},
+		// 3
+		{
+			in:       NewOrderedValues(Pair{"b[][id]", "1"}),
+			expected: nil,
+		},
+	}
+	for i, fixture := range fixtures {
+		elems, err := fixture.in.Elements("a")
+		if err != fixture.err {
+			t.Errorf("expected err=%v; got %v (i=%d)", fixture.err, err, i)
+			continue
+		}
+		var pairs [][]Pair
+		for _, elem := range elems {
+			pairs = append(pairs, elem.Pairs())
+		}
+		if !reflect.DeepEqual(pairs, fixture.expected) {
+			t.Errorf("expected %v; got %v (i=%d)", fixture.expected, pairs, i)
+		}
+	}
+}
+
+func TestValuesSetObjectElements(t *testing.T) {
+	v := NewOrderedValues(Pair{"a[x]", "old"}, Pair{"b", "1"})
+	v.SetObject("a", NewOrderedValues(Pair{"x", "1"}, Pair{"y[]", "2"}))
+	v.SetElements("c", []OrderedValues{
+		NewOrderedValues(Pair{"id", "1"}, Pair{"id", "2"}, Pair{"tags[]", "x"}),
+		NewOrderedValues(Pair{"id", "3"}),
+	})
+	expected := []Pair{
+		{"b", "1"}, {"a[x]", "1"}, {"a[y][]", "2"}, {"c[][id]", "1,2"},
+		{"c[][tags][]", "x"}, {"c[][id]", "3"},
+	}
+	if pairs := v.Pairs(); !reflect.DeepEqual(pairs, expected) {
+		t.Errorf("expected %v; got %v", expected, pairs)
+	}
+}
+
+func TestValuesNil(t *testing.T) {
+	m := NewOrderedValues(Pair{"a", "1"}, Pair{"b", "2"}, Pair{"c", ""})
+	m.SetNil("b")
+	m.SetNil("d")
+	for _, k := range []string{"b", "d"} {
+		if !m.IsNil(k) {
+			t.Errorf("expected %s to be nil", k)
+		}
+	}
+	for _, k := range []string{"a", "c", "e"} {
+		if m.IsNil(k) {
+			t.Errorf("expected %s not to be nil", k)
+		}
+	}
+	if !m.Ordered() {
+		t.Error("expected ordered values")
+	}
+	if expected, out := "a=1&b&c=&d", m.Encode(); out != expected {
+		t.Errorf("expected %s; got %s", expected, out)
+	}
+	unordered := Values{m.Values}
+	if expected, out := "a=1&b&c=&d", unordered.Encode(); out != expected {
+		t.Errorf("expected %s; got %s", expected, out)
+	}
+	m.Add("b", "3")
+	if expected, out := "a=1&b=3&c=&d", m.Encode(); out != expected {
+		t.Errorf("expected %s; got %s", expected, out)
+	}
+
+	nested := NewOrderedValues(Pair{"user[name]", "a"})
+	nested.SetNil("user[bio]")
+	obj := nested.Object("user")
+	if !obj.IsNil("bio") || obj.IsNil("name") {
+		t.Errorf("expected only bio to be nil; got %v", obj.Values)
+	}
+	var dst OrderedValues
+	dst.SetObject("user", obj)
+	expected := "user%5Bbio%5D&user%5Bname%5D=a"
+	if out := dst.Encode(); out != expected {
+		t.Errorf("expected %s; got %s", expected, out)
+	}
+}